@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"gopkg.in/urfave/cli.v2"
+)
+
+// loader merges the config file with the flags, values from the file take
+// precedence over defaults while flags and env variables take precedence over
+// the file.
+type loader struct {
+	ctx   *cli.Context
+	base  config.Config
+	paths [][]int
+}
+
+func newLoader(c *cli.Context, cfg *config.Config) *loader {
+	l := &loader{
+		ctx:  c,
+		base: *cfg,
+	}
+
+	root := reflect.ValueOf(cfg).Elem()
+
+	for _, f := range append(c.App.Flags, c.Command.Flags...) {
+		name, envs, dest := flagTarget(f)
+
+		if dest == nil || !flagSet(c, name, envs) {
+			continue
+		}
+
+		if path := fieldPath(root, reflect.ValueOf(dest).Pointer()); path != nil {
+			l.paths = append(l.paths, path)
+		}
+	}
+
+	return l
+}
+
+// Load builds a new configuration based on the file, flags and env variables.
+func (l *loader) Load() (*config.Config, error) {
+	cfg := l.base
+
+	if file := l.ctx.String("config"); file != "" {
+		if err := config.Load(file, &cfg); err != nil {
+			return nil, err
+		}
+
+		src := reflect.ValueOf(&l.base).Elem()
+		dst := reflect.ValueOf(&cfg).Elem()
+
+		for _, path := range l.paths {
+			dst.FieldByIndex(path).Set(src.FieldByIndex(path))
+		}
+	}
+
+	if err := sliceFlags(l.ctx, &cfg); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.LDAP.Servers) == 0 {
+		cfg.LDAP.Servers = []string{"ldap:389"}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// sliceFlags applies the flags which don't support a destination.
+func sliceFlags(c *cli.Context, cfg *config.Config) error {
+	if len(c.StringSlice("proxy-endpoint")) > 0 {
+		endpoints := make([]config.Endpoint, 0, len(c.StringSlice("proxy-endpoint")))
+
+		for _, val := range c.StringSlice("proxy-endpoint") {
+			endpoint, err := config.ParseEndpoint(val)
+
+			if err != nil {
+				return err
+			}
+
+			endpoints = append(endpoints, endpoint)
+		}
+
+		cfg.Proxy.Endpoints = endpoints
+	}
+
+	if len(c.StringSlice("proxy-stream-path")) > 0 {
+		cfg.Proxy.Stream.Paths = c.StringSlice("proxy-stream-path")
+	}
+
+	if len(c.StringSlice("ldap-address")) > 0 {
+		cfg.LDAP.Servers = c.StringSlice("ldap-address")
+	}
+
+	if len(c.StringSlice("proxy-header")) > 0 {
+		headers := make([]config.Header, 0, len(c.StringSlice("proxy-header")))
+
+		for _, header := range c.StringSlice("proxy-header") {
+			parts := strings.SplitN(header, ":", 2)
+
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("invalid proxy header mapping: %s", header)
+			}
+
+			headers = append(headers, config.Header{
+				Attribute: parts[0],
+				Name:      parts[1],
+			})
+		}
+
+		cfg.Proxy.Headers = headers
+	}
+
+	if len(c.StringSlice("access-rule")) > 0 {
+		rules := make([]config.Rule, 0, len(c.StringSlice("access-rule")))
+
+		for _, val := range c.StringSlice("access-rule") {
+			rule, err := parseRule(val)
+
+			if err != nil {
+				return err
+			}
+
+			rules = append(rules, rule)
+		}
+
+		cfg.Access.Rules = rules
+	}
+
+	if len(c.StringSlice("server-proxy")) > 0 {
+		cfg.Server.Proxies = c.StringSlice("server-proxy")
+	}
+
+	if len(c.StringSlice("session-secret")) > 0 {
+		cfg.Session.Secrets = c.StringSlice("session-secret")
+	}
+
+	if len(c.StringSlice("session-encryption")) > 0 {
+		cfg.Session.Encryption = c.StringSlice("session-encryption")
+	}
+
+	return nil
+}
+
+func parseRule(val string) (config.Rule, error) {
+	rule := config.Rule{}
+
+	for _, part := range strings.Split(val, ";") {
+		kv := strings.SplitN(part, "=", 2)
+
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("invalid access rule: %s", val)
+		}
+
+		switch strings.TrimSpace(kv[0]) {
+		case "host":
+			rule.Host = kv[1]
+		case "path":
+			rule.Path = kv[1]
+		case "regex":
+			rule.Regex = kv[1]
+		case "method":
+			rule.Methods = strings.Split(kv[1], "|")
+		case "allow":
+			rule.Allow = strings.Split(kv[1], "|")
+		case "deny":
+			rule.Deny = strings.Split(kv[1], "|")
+		default:
+			return rule, fmt.Errorf("invalid access rule key: %s", kv[0])
+		}
+	}
+
+	return rule, nil
+}
+
+func flagTarget(f cli.Flag) (string, []string, interface{}) {
+	switch v := f.(type) {
+	case *cli.StringFlag:
+		if v.Destination != nil {
+			return v.Name, v.EnvVars, v.Destination
+		}
+	case *cli.BoolFlag:
+		if v.Destination != nil {
+			return v.Name, v.EnvVars, v.Destination
+		}
+	case *cli.IntFlag:
+		if v.Destination != nil {
+			return v.Name, v.EnvVars, v.Destination
+		}
+	case *cli.Int64Flag:
+		if v.Destination != nil {
+			return v.Name, v.EnvVars, v.Destination
+		}
+	case *cli.DurationFlag:
+		if v.Destination != nil {
+			return v.Name, v.EnvVars, v.Destination
+		}
+	}
+
+	return "", nil, nil
+}
+
+func flagSet(c *cli.Context, name string, envs []string) bool {
+	for _, env := range envs {
+		if _, ok := os.LookupEnv(env); ok {
+			return true
+		}
+	}
+
+	for _, ctx := range c.Lineage() {
+		if ctx.IsSet(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fieldPath(v reflect.Value, ptr uintptr) []int {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+
+		if field.Addr().Pointer() == ptr && field.Kind() != reflect.Struct {
+			return []int{i}
+		}
+
+		if field.Kind() == reflect.Struct {
+			if path := fieldPath(field, ptr); path != nil {
+				return append([]int{i}, path...)
+			}
+		}
+	}
+
+	return nil
+}
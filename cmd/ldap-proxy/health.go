@@ -1,8 +1,15 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/webhippie/ldap-proxy/pkg/config"
@@ -28,15 +35,80 @@ func healthFlags(cfg *config.Config) []cli.Flag {
 			EnvVars:     []string{"LDAP_PROXY_HEALTH_ADDR"},
 			Destination: &cfg.Server.Health,
 		},
+		&cli.StringFlag{
+			Name:        "health-check",
+			Value:       "healthz",
+			Usage:       "endpoint to check, healthz or readyz",
+			EnvVars:     []string{"LDAP_PROXY_HEALTH_CHECK"},
+			Destination: &cfg.Health.Check,
+		},
+		&cli.DurationFlag{
+			Name:        "health-timeout",
+			Value:       5 * time.Second,
+			Usage:       "timeout for the health check",
+			EnvVars:     []string{"LDAP_PROXY_HEALTH_TIMEOUT"},
+			Destination: &cfg.Health.Timeout,
+		},
+		&cli.BoolFlag{
+			Name:        "health-secure",
+			Value:       false,
+			Usage:       "use https for the health check",
+			EnvVars:     []string{"LDAP_PROXY_HEALTH_SECURE"},
+			Destination: &cfg.Health.Secure,
+		},
+		&cli.StringFlag{
+			Name:        "health-ca",
+			Value:       "",
+			Usage:       "path to ca bundle to verify the health check",
+			EnvVars:     []string{"LDAP_PROXY_HEALTH_CA"},
+			Destination: &cfg.Health.CA,
+		},
+		&cli.BoolFlag{
+			Name:        "health-insecure",
+			Value:       false,
+			Usage:       "skip the certificate verification of the health check",
+			EnvVars:     []string{"LDAP_PROXY_HEALTH_INSECURE"},
+			Destination: &cfg.Health.Insecure,
+		},
 	}
 }
 
 func healthAction(cfg *config.Config) cli.ActionFunc {
 	return func(c *cli.Context) error {
-		resp, err := http.Get(
+		switch cfg.Health.Check {
+		case "healthz", "readyz":
+		default:
+			err := fmt.Errorf("invalid health check: %s", cfg.Health.Check)
+
+			log.Error().
+				Err(err).
+				Msg("failed to prepare health check")
+
+			return err
+		}
+
+		client, err := healthClient(cfg)
+
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to initialize health client")
+
+			return err
+		}
+
+		scheme := "http"
+
+		if cfg.Health.Secure {
+			scheme = "https"
+		}
+
+		resp, err := client.Get(
 			fmt.Sprintf(
-				"http://%s/healthz",
+				"%s://%s/%s",
+				scheme,
 				cfg.Server.Health,
+				cfg.Health.Check,
 			),
 		)
 
@@ -50,7 +122,28 @@ func healthAction(cfg *config.Config) cli.ActionFunc {
 
 		defer resp.Body.Close()
 
-		if resp.StatusCode != 200 {
+		body, err := ioutil.ReadAll(resp.Body)
+
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to read health check")
+
+			return err
+		}
+
+		var result interface{}
+
+		if err := json.Unmarshal(body, &result); err == nil {
+			pretty, _ := json.MarshalIndent(result, "", "  ")
+			fmt.Fprintln(os.Stdout, string(pretty))
+		} else {
+			fmt.Fprintln(os.Stdout, string(body))
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err := fmt.Errorf("unexpected status code %d", resp.StatusCode)
+
 			log.Error().
 				Err(err).
 				Msg("health seems to be in a bad state")
@@ -61,3 +154,39 @@ func healthAction(cfg *config.Config) cli.ActionFunc {
 		return nil
 	}
 }
+
+func healthClient(cfg *config.Config) (*http.Client, error) {
+	client := &http.Client{
+		Timeout: cfg.Health.Timeout,
+	}
+
+	if cfg.Health.CA == "" && !cfg.Health.Insecure {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.Health.Insecure,
+	}
+
+	if cfg.Health.CA != "" {
+		content, err := ioutil.ReadFile(cfg.Health.CA)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, errors.New("failed to parse ca certificates")
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	client.Transport = &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+
+	return client, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+func TestHealthTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			w.Write([]byte(`{"status":"ok"}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"status":"failure"}`))
+		}
+	}))
+
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "health")
+
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ca := filepath.Join(dir, "ca.pem")
+
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0600); err != nil {
+		t.Fatalf("failed to write ca: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		check    string
+		secure   bool
+		ca       string
+		insecure bool
+		healthy  bool
+	}{
+		{"ca", "healthz", true, ca, false, true},
+		{"insecure", "healthz", true, "", true, true},
+		{"unknown ca", "healthz", true, "", false, false},
+		{"plain http", "healthz", false, ca, false, false},
+		{"unhealthy", "readyz", true, ca, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New()
+			cfg.Server.Health = srv.Listener.Addr().String()
+			cfg.Health.Check = tt.check
+			cfg.Health.Timeout = 5 * time.Second
+			cfg.Health.Secure = tt.secure
+			cfg.Health.CA = tt.ca
+			cfg.Health.Insecure = tt.insecure
+
+			if err := healthAction(cfg)(nil); (err == nil) != tt.healthy {
+				t.Errorf("expected healthy %v, got %v", tt.healthy, err)
+			}
+		})
+	}
+}
+
+func TestHealthInvalidCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health")
+
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	ca := filepath.Join(dir, "ca.pem")
+
+	if err := ioutil.WriteFile(ca, []byte("invalid"), 0600); err != nil {
+		t.Fatalf("failed to write ca: %s", err)
+	}
+
+	cfg := config.New()
+	cfg.Health.CA = ca
+
+	if _, err := healthClient(cfg); err == nil {
+		t.Errorf("expected invalid ca to be rejected")
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/health"
+	"github.com/webhippie/ldap-proxy/pkg/templates"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
+)
+
+// reloadable lists the config keys which get applied without a restart.
+var reloadable = []string{
+	"access.",
+	"proxy.endpoints",
+	"proxy.user_header",
+	"proxy.headers",
+	"proxy.routes",
+	"proxy.probe",
+	"proxy.breaker",
+	"proxy.balancer",
+	"proxy.stream",
+	"proxy.limits",
+	"proxy.basic",
+	"server.templates",
+	"server.cert",
+	"server.key",
+}
+
+// reloader applies a changed configuration to the running server.
+type reloader struct {
+	mu     sync.Mutex
+	loader *loader
+
+	// current reflects the running configuration, changes which require a
+	// restart are not part of it.
+	current *config.Config
+
+	// attributes are fetched from LDAP and don't change without a restart,
+	// even if they are mapped to headers by reloadable values.
+	attributes []string
+
+	routes  *upstream.Table
+	keypair *keypair
+	checks  *health.Health
+}
+
+// Reload loads the configuration again and applies the changes, an invalid
+// configuration gets rejected and the previous one stays active. Everything
+// gets prepared before the changes are applied together.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.loader.Load()
+
+	if err != nil {
+		if errs, ok := err.(config.Errors); ok {
+			for _, err := range errs {
+				log.Error().
+					Err(err).
+					Msg("invalid configuration, keeping previous one")
+			}
+		} else {
+			log.Error().
+				Err(err).
+				Msg("failed to reload configuration, keeping previous one")
+		}
+
+		return err
+	}
+
+	cert, err := loadKeypair(next)
+
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to reload certificates, keeping previous one")
+
+		return err
+	}
+
+	tpls, err := templates.Prepare(next)
+
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to reload templates, keeping previous one")
+
+		return err
+	}
+
+	routes, err := r.routes.Prepare(next)
+
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to reload routes, keeping previous one")
+
+		return err
+	}
+
+	r.keypair.Set(cert)
+	tpls()
+	routes()
+
+	r.checks.Register("upstream", health.Upstreams(r.routes.Servers, next.Proxy.Probe.Timeout))
+	r.checks.Register("templates", health.Templates(next))
+	r.checks.Register("certificates", health.Certificates(next))
+
+	if !reflect.DeepEqual(r.attributes, next.Attributes()) {
+		log.Warn().
+			Msg("mapped header attributes changed, requires a restart")
+	}
+
+	for _, key := range diff(reflect.ValueOf(*r.current), reflect.ValueOf(*next), "") {
+		if isReloadable(key) {
+			log.Info().
+				Str("key", key).
+				Msg("configuration changed")
+		} else {
+			log.Warn().
+				Str("key", key).
+				Msg("configuration changed, requires a restart")
+		}
+	}
+
+	r.current = running(r.current, next)
+
+	log.Info().
+		Msg("reloaded configuration")
+
+	return nil
+}
+
+// Watch reloads the configuration whenever the config file changes until the
+// done channel gets closed.
+func (r *reloader) Watch(file string, done <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	defer watcher.Close()
+
+	// Watch the directory, editors and config maps replace the file.
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		return err
+	}
+
+	var debounce <-chan time.Time
+
+	for {
+		select {
+		case event := <-watcher.Events:
+			if filepath.Clean(event.Name) == filepath.Clean(file) {
+				debounce = time.After(500 * time.Millisecond)
+			}
+		case err := <-watcher.Errors:
+			log.Warn().
+				Err(err).
+				Msg("failed to watch config file")
+		case <-debounce:
+			r.Reload()
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// keypair provides the certificate for the TLS listener and supports
+// replacing it at runtime.
+type keypair struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// Set replaces the current certificate, nil values are ignored.
+func (k *keypair) Set(cert *tls.Certificate) {
+	if cert == nil {
+		return
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.cert = cert
+}
+
+// GetCertificate implements the callback of the TLS configuration.
+func (k *keypair) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.cert, nil
+}
+
+// loadKeypair reads the certificate and key from the configured paths.
+func loadKeypair(cfg *config.Config) (*tls.Certificate, error) {
+	if cfg.Server.Cert == "" || cfg.Server.Key == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(
+		cfg.Server.Cert,
+		cfg.Server.Key,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// diff returns the keys of all values which differ between both configs.
+func diff(prev, next reflect.Value, prefix string) []string {
+	if prev.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(prev.Interface(), next.Interface()) {
+			return []string{prefix}
+		}
+
+		return nil
+	}
+
+	result := []string{}
+
+	for i := 0; i < prev.NumField(); i++ {
+		key := strings.Split(prev.Type().Field(i).Tag.Get("yaml"), ",")[0]
+
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		result = append(result, diff(prev.Field(i), next.Field(i), key)...)
+	}
+
+	return result
+}
+
+// running returns a copy of the current config with the reloadable values of
+// the next config, the other values only change with a restart.
+func running(current, next *config.Config) *config.Config {
+	result := *current
+	merge(reflect.ValueOf(&result).Elem(), reflect.ValueOf(*next), "")
+
+	return &result
+}
+
+// merge sets all reloadable values of the destination to the source values.
+func merge(dst, src reflect.Value, prefix string) {
+	if dst.Kind() != reflect.Struct {
+		if isReloadable(prefix) {
+			dst.Set(src)
+		}
+
+		return
+	}
+
+	for i := 0; i < dst.NumField(); i++ {
+		key := strings.Split(dst.Type().Field(i).Tag.Get("yaml"), ",")[0]
+
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		merge(dst.Field(i), src.Field(i), key)
+	}
+}
+
+func isReloadable(key string) bool {
+	for _, prefix := range reloadable {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
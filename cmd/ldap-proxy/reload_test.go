@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+func TestRunning(t *testing.T) {
+	current := config.New()
+	current.Server.Secure = "0.0.0.0:443"
+	current.Server.Templates = "/old"
+	current.Access.Default = "allow"
+
+	next := config.New()
+	next.Server.Secure = "0.0.0.0:8443"
+	next.Server.Templates = "/new"
+	next.Access.Default = "deny"
+
+	result := running(current, next)
+
+	if result.Server.Secure != "0.0.0.0:443" {
+		t.Errorf("expected address requiring a restart to be kept, got %s", result.Server.Secure)
+	}
+
+	if result.Server.Templates != "/new" || result.Access.Default != "deny" {
+		t.Errorf("expected reloadable values to be applied, got %s and %s", result.Server.Templates, result.Access.Default)
+	}
+
+	if current.Server.Templates != "/old" {
+		t.Errorf("expected current config to stay untouched")
+	}
+
+	// Reloading the same file again still reports the pending restart.
+	if keys := diff(reflect.ValueOf(*result), reflect.ValueOf(*next), ""); !reflect.DeepEqual(keys, []string{"server.secure"}) {
+		t.Errorf("expected only the pending restart to differ, got %v", keys)
+	}
+}
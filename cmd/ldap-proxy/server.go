@@ -8,15 +8,21 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/oklog/run"
 	"github.com/rs/zerolog/log"
-	"github.com/vulcand/oxy/buffer"
-	"github.com/vulcand/oxy/forward"
-	"github.com/vulcand/oxy/roundrobin"
 	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/directory"
+	"github.com/webhippie/ldap-proxy/pkg/handler"
+	"github.com/webhippie/ldap-proxy/pkg/health"
+	"github.com/webhippie/ldap-proxy/pkg/lockout"
+	"github.com/webhippie/ldap-proxy/pkg/pool"
 	"github.com/webhippie/ldap-proxy/pkg/router"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
 	"golang.org/x/crypto/acme/autocert"
 	"gopkg.in/urfave/cli.v2"
 )
@@ -29,17 +35,31 @@ var (
 
 // Server provides the sub-command to start the server.
 func Server(cfg *config.Config) *cli.Command {
+	ld := &loader{}
+
 	return &cli.Command{
 		Name:   "server",
 		Usage:  "start the integrated server",
 		Flags:  serverFlags(cfg),
-		Before: serverBefore(cfg),
-		Action: serverAction(cfg),
+		Before: serverBefore(cfg, ld),
+		Action: serverAction(cfg, ld),
 	}
 }
 
 func serverFlags(cfg *config.Config) []cli.Flag {
 	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Value:   "",
+			Usage:   "path to config file in yaml, toml or json format",
+			EnvVars: []string{"LDAP_PROXY_CONFIG"},
+		},
+		&cli.BoolFlag{
+			Name:    "config-watch",
+			Value:   false,
+			Usage:   "reload the config file on changes",
+			EnvVars: []string{"LDAP_PROXY_CONFIG_WATCH"},
+		},
 		&cli.StringFlag{
 			Name:        "health-addr",
 			Value:       healthAddr,
@@ -131,6 +151,54 @@ func serverFlags(cfg *config.Config) []cli.Flag {
 			EnvVars:     []string{"LDAP_PROXY_SERVER_STORAGE"},
 			Destination: &cfg.Server.Storage,
 		},
+		&cli.StringSliceFlag{
+			Name:    "server-proxy",
+			Value:   cli.NewStringSlice(),
+			Usage:   "trusted reverse proxies to take the client address from, like 10.0.0.0/8",
+			EnvVars: []string{"LDAP_PROXY_SERVER_PROXIES"},
+		},
+		&cli.DurationFlag{
+			Name:        "server-drain",
+			Value:       5 * time.Second,
+			Usage:       "period to report not ready before shutting down",
+			EnvVars:     []string{"LDAP_PROXY_SERVER_DRAIN"},
+			Destination: &cfg.Server.Drain,
+		},
+		&cli.DurationFlag{
+			Name:        "server-shutdown",
+			Value:       30 * time.Second,
+			Usage:       "timeout to finish in-flight requests on shutdown",
+			EnvVars:     []string{"LDAP_PROXY_SERVER_SHUTDOWN"},
+			Destination: &cfg.Server.Shutdown,
+		},
+		&cli.DurationFlag{
+			Name:        "server-read-timeout",
+			Value:       0,
+			Usage:       "timeout to read a whole request including the body",
+			EnvVars:     []string{"LDAP_PROXY_SERVER_READ_TIMEOUT"},
+			Destination: &cfg.Server.ReadTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "server-header-timeout",
+			Value:       5 * time.Second,
+			Usage:       "timeout to read the request headers",
+			EnvVars:     []string{"LDAP_PROXY_SERVER_HEADER_TIMEOUT"},
+			Destination: &cfg.Server.HeaderTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "server-write-timeout",
+			Value:       0,
+			Usage:       "timeout to write a whole response, limits streams as well",
+			EnvVars:     []string{"LDAP_PROXY_SERVER_WRITE_TIMEOUT"},
+			Destination: &cfg.Server.WriteTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "server-idle-timeout",
+			Value:       2 * time.Minute,
+			Usage:       "timeout for idle keep-alive connections",
+			EnvVars:     []string{"LDAP_PROXY_SERVER_IDLE_TIMEOUT"},
+			Destination: &cfg.Server.IdleTimeout,
+		},
 		&cli.StringFlag{
 			Name:        "proxy-title",
 			Value:       "LDAP Proxy",
@@ -141,7 +209,7 @@ func serverFlags(cfg *config.Config) []cli.Flag {
 		&cli.StringSliceFlag{
 			Name:    "proxy-endpoint",
 			Value:   cli.NewStringSlice(),
-			Usage:   "endpoints to proxy requests to",
+			Usage:   "endpoints to proxy requests to, like http://app:8080;weight=3",
 			EnvVars: []string{"LDAP_PROXY_SERVER_ENDPOINTS"},
 		},
 		&cli.StringFlag{
@@ -151,12 +219,219 @@ func serverFlags(cfg *config.Config) []cli.Flag {
 			EnvVars:     []string{"LDAP_PROXY_USER_HEADER"},
 			Destination: &cfg.Proxy.UserHeader,
 		},
+		&cli.StringSliceFlag{
+			Name:    "proxy-header",
+			Value:   cli.NewStringSlice(),
+			Usage:   "map ldap attributes to headers, like mail:X-PROXY-MAIL",
+			EnvVars: []string{"LDAP_PROXY_HEADERS"},
+		},
 		&cli.StringFlag{
-			Name:        "ldap-address",
-			Value:       "ldap:389",
-			Usage:       "hostname of the ldap server",
-			EnvVars:     []string{"LDAP_PROXY_SERVER_ADDRESS"},
-			Destination: &cfg.LDAP.Addr,
+			Name:        "proxy-probe-path",
+			Value:       "",
+			Usage:       "path to probe the upstream endpoints, disabled if empty",
+			EnvVars:     []string{"LDAP_PROXY_PROBE_PATH"},
+			Destination: &cfg.Proxy.Probe.Path,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-probe-interval",
+			Value:       10 * time.Second,
+			Usage:       "interval to probe the upstream endpoints",
+			EnvVars:     []string{"LDAP_PROXY_PROBE_INTERVAL"},
+			Destination: &cfg.Proxy.Probe.Interval,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-probe-timeout",
+			Value:       2 * time.Second,
+			Usage:       "timeout for a single upstream probe",
+			EnvVars:     []string{"LDAP_PROXY_PROBE_TIMEOUT"},
+			Destination: &cfg.Proxy.Probe.Timeout,
+		},
+		&cli.IntFlag{
+			Name:        "proxy-probe-threshold",
+			Value:       3,
+			Usage:       "failed probes in a row to remove an upstream endpoint",
+			EnvVars:     []string{"LDAP_PROXY_PROBE_THRESHOLD"},
+			Destination: &cfg.Proxy.Probe.Threshold,
+		},
+		&cli.StringFlag{
+			Name:        "proxy-breaker",
+			Value:       "",
+			Usage:       "circuit breaker expression, like NetworkErrorRatio() > 0.5",
+			EnvVars:     []string{"LDAP_PROXY_BREAKER"},
+			Destination: &cfg.Proxy.Breaker.Expression,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-breaker-fallback",
+			Value:       10 * time.Second,
+			Usage:       "duration to reject requests after the breaker tripped",
+			EnvVars:     []string{"LDAP_PROXY_BREAKER_FALLBACK"},
+			Destination: &cfg.Proxy.Breaker.Fallback,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-breaker-recovery",
+			Value:       10 * time.Second,
+			Usage:       "duration to gradually recover after the fallback",
+			EnvVars:     []string{"LDAP_PROXY_BREAKER_RECOVERY"},
+			Destination: &cfg.Proxy.Breaker.Recovery,
+		},
+		&cli.BoolFlag{
+			Name:        "proxy-sticky",
+			Value:       false,
+			Usage:       "bind authenticated users to an upstream endpoint",
+			EnvVars:     []string{"LDAP_PROXY_STICKY"},
+			Destination: &cfg.Proxy.Balancer.Sticky,
+		},
+		&cli.BoolFlag{
+			Name:        "proxy-rebalance",
+			Value:       false,
+			Usage:       "adjust upstream weights based on error rates",
+			EnvVars:     []string{"LDAP_PROXY_REBALANCE"},
+			Destination: &cfg.Proxy.Balancer.Rebalance,
+		},
+		&cli.BoolFlag{
+			Name:        "proxy-basic",
+			Value:       false,
+			Usage:       "accept basic auth credentials from clients which are not browsers",
+			EnvVars:     []string{"LDAP_PROXY_BASIC"},
+			Destination: &cfg.Proxy.Basic.Enabled,
+		},
+		&cli.StringFlag{
+			Name:        "proxy-basic-realm",
+			Value:       "LDAP Proxy",
+			Usage:       "realm of the basic auth challenge",
+			EnvVars:     []string{"LDAP_PROXY_BASIC_REALM"},
+			Destination: &cfg.Proxy.Basic.Realm,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-basic-ttl",
+			Value:       time.Minute,
+			Usage:       "duration to cache successful basic authentications, disabled if zero",
+			EnvVars:     []string{"LDAP_PROXY_BASIC_TTL"},
+			Destination: &cfg.Proxy.Basic.TTL,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-stream-lifetime",
+			Value:       0,
+			Usage:       "maximum lifetime of websockets and streams, limited by the session",
+			EnvVars:     []string{"LDAP_PROXY_STREAM_LIFETIME"},
+			Destination: &cfg.Proxy.Stream.Lifetime,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-stream-flush",
+			Value:       100 * time.Millisecond,
+			Usage:       "interval to flush streamed responses",
+			EnvVars:     []string{"LDAP_PROXY_STREAM_FLUSH"},
+			Destination: &cfg.Proxy.Stream.Flush,
+		},
+		&cli.StringSliceFlag{
+			Name:    "proxy-stream-path",
+			Value:   cli.NewStringSlice(),
+			Usage:   "path prefixes to stream without buffering",
+			EnvVars: []string{"LDAP_PROXY_STREAM_PATHS"},
+		},
+		&cli.Int64Flag{
+			Name:        "proxy-max-request-body",
+			Value:       0,
+			Usage:       "maximum size of request bodies in bytes, unlimited if zero",
+			EnvVars:     []string{"LDAP_PROXY_MAX_REQUEST_BODY"},
+			Destination: &cfg.Proxy.Limits.RequestBody,
+		},
+		&cli.Int64Flag{
+			Name:        "proxy-max-response-body",
+			Value:       0,
+			Usage:       "maximum size of buffered response bodies in bytes, unlimited if zero",
+			EnvVars:     []string{"LDAP_PROXY_MAX_RESPONSE_BODY"},
+			Destination: &cfg.Proxy.Limits.ResponseBody,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-timeout",
+			Value:       60 * time.Second,
+			Usage:       "timeout for proxied requests, streams are excluded",
+			EnvVars:     []string{"LDAP_PROXY_REQUEST_TIMEOUT"},
+			Destination: &cfg.Proxy.Limits.Timeout,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-dial-timeout",
+			Value:       10 * time.Second,
+			Usage:       "timeout to connect to the upstream endpoints",
+			EnvVars:     []string{"LDAP_PROXY_DIAL_TIMEOUT"},
+			Destination: &cfg.Proxy.Limits.DialTimeout,
+		},
+		&cli.DurationFlag{
+			Name:        "proxy-header-timeout",
+			Value:       0,
+			Usage:       "timeout to receive the response headers of the upstreams",
+			EnvVars:     []string{"LDAP_PROXY_UPSTREAM_HEADER_TIMEOUT"},
+			Destination: &cfg.Proxy.Limits.HeaderTimeout,
+		},
+		&cli.StringSliceFlag{
+			Name:    "ldap-address",
+			Value:   cli.NewStringSlice(),
+			Usage:   "addresses of the ldap servers, like ldaps://ldap:636, defaults to ldap:389",
+			EnvVars: []string{"LDAP_PROXY_SERVER_ADDRESS"},
+		},
+		&cli.IntFlag{
+			Name:        "ldap-pool-size",
+			Value:       10,
+			Usage:       "maximum of idle ldap connections",
+			EnvVars:     []string{"LDAP_PROXY_POOL_SIZE"},
+			Destination: &cfg.LDAP.PoolSize,
+		},
+		&cli.DurationFlag{
+			Name:        "ldap-timeout",
+			Value:       5 * time.Second,
+			Usage:       "timeout for ldap connections and requests",
+			EnvVars:     []string{"LDAP_PROXY_TIMEOUT"},
+			Destination: &cfg.LDAP.Timeout,
+		},
+		&cli.DurationFlag{
+			Name:        "ldap-interval",
+			Value:       30 * time.Second,
+			Usage:       "interval to probe the ldap servers",
+			EnvVars:     []string{"LDAP_PROXY_INTERVAL"},
+			Destination: &cfg.LDAP.Interval,
+		},
+		&cli.BoolFlag{
+			Name:        "ldap-starttls",
+			Value:       false,
+			Usage:       "upgrade plain ldap connections with starttls",
+			EnvVars:     []string{"LDAP_PROXY_STARTTLS"},
+			Destination: &cfg.LDAP.StartTLS,
+		},
+		&cli.StringFlag{
+			Name:        "ldap-ca",
+			Value:       "",
+			Usage:       "path to ca bundle to verify the ldap server",
+			EnvVars:     []string{"LDAP_PROXY_CA"},
+			Destination: &cfg.LDAP.CA,
+		},
+		&cli.StringFlag{
+			Name:        "ldap-cert",
+			Value:       "",
+			Usage:       "path to client cert for the ldap server",
+			EnvVars:     []string{"LDAP_PROXY_CERT"},
+			Destination: &cfg.LDAP.Cert,
+		},
+		&cli.StringFlag{
+			Name:        "ldap-key",
+			Value:       "",
+			Usage:       "path to client key for the ldap server",
+			EnvVars:     []string{"LDAP_PROXY_KEY"},
+			Destination: &cfg.LDAP.Key,
+		},
+		&cli.StringFlag{
+			Name:        "ldap-server-name",
+			Value:       "",
+			Usage:       "override the server name to verify the ldap server",
+			EnvVars:     []string{"LDAP_PROXY_SERVER_NAME"},
+			Destination: &cfg.LDAP.ServerName,
+		},
+		&cli.BoolFlag{
+			Name:        "ldap-insecure",
+			Value:       false,
+			Usage:       "skip verification of the ldap server certificate",
+			EnvVars:     []string{"LDAP_PROXY_INSECURE"},
+			Destination: &cfg.LDAP.Insecure,
 		},
 		&cli.StringFlag{
 			Name:        "ldap-username",
@@ -193,94 +468,411 @@ func serverFlags(cfg *config.Config) []cli.Flag {
 			EnvVars:     []string{"LDAP_PROXY_USER_ATTR"},
 			Destination: &cfg.LDAP.UserAttr,
 		},
+		&cli.StringFlag{
+			Name:        "ldap-group-mode",
+			Value:       "memberof",
+			Usage:       "group resolution, one of " + strings.Join(config.GroupModes, ", "),
+			EnvVars:     []string{"LDAP_PROXY_GROUP_MODE"},
+			Destination: &cfg.LDAP.GroupMode,
+		},
+		&cli.StringFlag{
+			Name:        "ldap-group-base",
+			Value:       "",
+			Usage:       "base dn for group searches, defaults to base dn",
+			EnvVars:     []string{"LDAP_PROXY_GROUP_BASE"},
+			Destination: &cfg.LDAP.GroupBase,
+		},
+		&cli.IntFlag{
+			Name:        "ldap-group-depth",
+			Value:       5,
+			Usage:       "maximum depth to resolve nested groups",
+			EnvVars:     []string{"LDAP_PROXY_GROUP_DEPTH"},
+			Destination: &cfg.LDAP.GroupDepth,
+		},
+		&cli.StringFlag{
+			Name:        "access-default",
+			Value:       "allow",
+			Usage:       "access if no rule matches, allow or deny",
+			EnvVars:     []string{"LDAP_PROXY_ACCESS_DEFAULT"},
+			Destination: &cfg.Access.Default,
+		},
+		&cli.StringSliceFlag{
+			Name:    "access-rule",
+			Value:   cli.NewStringSlice(),
+			Usage:   "access rules, like host=example.com;path=/admin;method=GET|POST;allow=admins;deny=guests",
+			EnvVars: []string{"LDAP_PROXY_ACCESS_RULES"},
+		},
+		&cli.StringFlag{
+			Name:        "session-name",
+			Value:       "ldap_proxy_session",
+			Usage:       "name of the session cookie",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_NAME"},
+			Destination: &cfg.Session.Name,
+		},
+		&cli.StringSliceFlag{
+			Name:    "session-secret",
+			Value:   cli.NewStringSlice(),
+			Usage:   "secrets to sign sessions, first one signs, others verify",
+			EnvVars: []string{"LDAP_PROXY_SESSION_SECRETS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "session-encryption",
+			Value:   cli.NewStringSlice(),
+			Usage:   "optional keys to encrypt sessions, matched to secrets by order",
+			EnvVars: []string{"LDAP_PROXY_SESSION_ENCRYPTION"},
+		},
+		&cli.DurationFlag{
+			Name:        "session-expire",
+			Value:       time.Hour * 24,
+			Usage:       "lifetime of an issued session",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_EXPIRE"},
+			Destination: &cfg.Session.Expire,
+		},
+		&cli.DurationFlag{
+			Name:        "session-idle",
+			Value:       0,
+			Usage:       "timeout for sessions without activity, disabled if zero",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_IDLE"},
+			Destination: &cfg.Session.Idle,
+		},
+		&cli.DurationFlag{
+			Name:        "session-lifetime",
+			Value:       0,
+			Usage:       "absolute lifetime of sliding sessions, unlimited if zero",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_LIFETIME"},
+			Destination: &cfg.Session.Lifetime,
+		},
+		&cli.BoolFlag{
+			Name:        "session-sliding",
+			Value:       false,
+			Usage:       "renew the expiry of sessions on activity",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_SLIDING"},
+			Destination: &cfg.Session.Sliding,
+		},
+		&cli.StringFlag{
+			Name:        "session-store",
+			Value:       "memory",
+			Usage:       "session store, one of memory, file or redis",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_STORE"},
+			Destination: &cfg.Session.Store,
+		},
+		&cli.IntFlag{
+			Name:        "session-capacity",
+			Value:       10000,
+			Usage:       "maximum sessions of the memory store, unlimited if zero",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_CAPACITY"},
+			Destination: &cfg.Session.Capacity,
+		},
+		&cli.StringFlag{
+			Name:        "session-redis-addr",
+			Value:       "",
+			Usage:       "address of the redis server for the session store",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_REDIS_ADDR"},
+			Destination: &cfg.Session.Redis.Addr,
+		},
+		&cli.StringFlag{
+			Name:        "session-redis-password",
+			Value:       "",
+			Usage:       "password of the redis server for the session store",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_REDIS_PASSWORD"},
+			Destination: &cfg.Session.Redis.Password,
+		},
+		&cli.IntFlag{
+			Name:        "session-redis-db",
+			Value:       0,
+			Usage:       "database of the redis server for the session store",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_REDIS_DB"},
+			Destination: &cfg.Session.Redis.DB,
+		},
+		&cli.StringFlag{
+			Name:        "session-redis-prefix",
+			Value:       "ldap_proxy:session:",
+			Usage:       "key prefix of the sessions within redis",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_REDIS_PREFIX"},
+			Destination: &cfg.Session.Redis.Prefix,
+		},
+		&cli.StringFlag{
+			Name:        "session-logout",
+			Value:       "",
+			Usage:       "optional url to redirect to after the logout",
+			EnvVars:     []string{"LDAP_PROXY_SESSION_LOGOUT"},
+			Destination: &cfg.Session.Logout,
+		},
+		&cli.IntFlag{
+			Name:        "lockout-threshold",
+			Value:       5,
+			Usage:       "failed logins of a username before it gets locked, disabled if zero",
+			EnvVars:     []string{"LDAP_PROXY_LOCKOUT_THRESHOLD"},
+			Destination: &cfg.Lockout.Threshold,
+		},
+		&cli.IntFlag{
+			Name:        "lockout-ip-threshold",
+			Value:       20,
+			Usage:       "failed logins of a client address before it gets locked, disabled if zero",
+			EnvVars:     []string{"LDAP_PROXY_LOCKOUT_IP_THRESHOLD"},
+			Destination: &cfg.Lockout.IPThreshold,
+		},
+		&cli.DurationFlag{
+			Name:        "lockout-duration",
+			Value:       15 * time.Minute,
+			Usage:       "duration of a lockout and to remember failed logins",
+			EnvVars:     []string{"LDAP_PROXY_LOCKOUT_DURATION"},
+			Destination: &cfg.Lockout.Duration,
+		},
+		&cli.DurationFlag{
+			Name:        "lockout-backoff",
+			Value:       time.Second,
+			Usage:       "initial delay after a failed login, doubled by every failure",
+			EnvVars:     []string{"LDAP_PROXY_LOCKOUT_BACKOFF"},
+			Destination: &cfg.Lockout.Backoff,
+		},
+		&cli.DurationFlag{
+			Name:        "lockout-max-backoff",
+			Value:       30 * time.Second,
+			Usage:       "maximum delay after failed logins",
+			EnvVars:     []string{"LDAP_PROXY_LOCKOUT_MAX_BACKOFF"},
+			Destination: &cfg.Lockout.MaxBackoff,
+		},
+		&cli.StringFlag{
+			Name:        "admin-group",
+			Value:       "",
+			Usage:       "dn of the ldap group allowed to use the admin api",
+			EnvVars:     []string{"LDAP_PROXY_ADMIN_GROUP"},
+			Destination: &cfg.Admin.Group,
+		},
+		&cli.StringFlag{
+			Name:        "admin-token",
+			Value:       "",
+			Usage:       "static bearer token for the admin api",
+			EnvVars:     []string{"LDAP_PROXY_ADMIN_TOKEN"},
+			Destination: &cfg.Admin.Token,
+		},
 	}
 }
 
-func serverBefore(cfg *config.Config) cli.BeforeFunc {
+func serverBefore(cfg *config.Config, ld *loader) cli.BeforeFunc {
 	return func(c *cli.Context) error {
-		if len(c.StringSlice("proxy-endpoint")) > 0 {
-			// StringSliceFlag doesn't support Destination
-			cfg.Proxy.Endpoints = c.StringSlice("proxy-endpoint")
+		*ld = *newLoader(c, cfg)
+
+		loaded, err := ld.Load()
+
+		if err != nil {
+			if errs, ok := err.(config.Errors); ok {
+				for _, err := range errs {
+					log.Error().
+						Err(err).
+						Msg("invalid configuration")
+				}
+			} else {
+				log.Error().
+					Err(err).
+					Msg("failed to load configuration")
+			}
+
+			return err
 		}
 
-		return nil
+		*cfg = *loaded
+
+		return before(cfg)(c)
 	}
 }
 
-func serverAction(cfg *config.Config) cli.ActionFunc {
+func serverAction(cfg *config.Config, ld *loader) cli.ActionFunc {
 	return func(c *cli.Context) error {
-		fwd, err := forward.New(
-			forward.PassHostHeader(true),
-		)
+		conns, err := pool.New(cfg)
 
 		if err != nil {
 			log.Error().
 				Err(err).
-				Msg("failed to initialize forwarder")
+				Msg("failed to initialize ldap pool")
 
 			return err
 		}
 
-		lb, err := roundrobin.New(fwd)
+		dir := directory.New(cfg, conns)
+
+		routes, err := upstream.New(cfg, handler.Upstream(cfg))
 
 		if err != nil {
 			log.Error().
 				Err(err).
-				Msg("failed to initialize balancer")
+				Msg("failed to initialize routes")
 
 			return err
 		}
 
-		proxy, err := buffer.New(
-			lb,
-			buffer.Retry(`IsNetworkError() && Attempts() < 3`),
-		)
+		store, err := session.NewStore(cfg)
 
 		if err != nil {
 			log.Error().
 				Err(err).
-				Msg("failed to initialize buffer")
+				Str("store", cfg.Session.Store).
+				Msg("failed to initialize session store")
 
 			return err
 		}
 
-		for _, endpoint := range cfg.Proxy.Endpoints {
-			parsed, err := url.Parse(endpoint)
+		defer store.Close()
 
-			if err != nil {
-				log.Warn().
-					Err(err).
-					Str("endpoint", endpoint).
-					Msg("failed to parse endpoint")
+		cache := session.NewCache()
+		sessions, err := session.New(cfg, store, cache)
 
-				continue
-			}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to initialize sessions")
+
+			return err
+		}
 
-			lb.UpsertServer(parsed)
+		guard := lockout.New(cfg)
+
+		certs := &keypair{}
+
+		checks := health.New()
+		checks.Register("ldap", health.LDAP(conns))
+		checks.Register("upstream", health.Upstreams(routes.Servers, cfg.Proxy.Probe.Timeout))
+		checks.Register("templates", health.Templates(cfg))
+		checks.Register("certificates", health.Certificates(cfg))
+
+		reload := &reloader{
+			loader:     ld,
+			current:    cfg,
+			attributes: cfg.Attributes(),
+			routes:     routes,
+			keypair:    certs,
+			checks:     checks,
 		}
 
 		var gr run.Group
 
 		{
 			stop := make(chan os.Signal, 1)
+			done := make(chan struct{})
 
 			gr.Add(func() error {
-				signal.Notify(stop, os.Interrupt)
+				signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
-				<-stop
+				select {
+				case sig := <-stop:
+					log.Info().
+						Str("signal", sig.String()).
+						Dur("drain", cfg.Server.Drain).
+						Msg("received signal, draining server")
+				case <-done:
+					return nil
+				}
+
+				checks.Drain()
+
+				select {
+				case <-time.After(cfg.Server.Drain):
+				case <-stop:
+					log.Info().
+						Msg("received second signal, skipping drain")
+				case <-done:
+				}
 
 				return nil
-			}, func(err error) {
-				close(stop)
+			}, func(reason error) {
+				signal.Stop(stop)
+				close(done)
+			})
+		}
+
+		{
+			hup := make(chan os.Signal, 1)
+			done := make(chan struct{})
+
+			gr.Add(func() error {
+				signal.Notify(hup, syscall.SIGHUP)
+
+				for {
+					select {
+					case <-hup:
+						log.Info().
+							Msg("received hangup, reloading configuration")
+
+						reload.Reload()
+					case <-done:
+						return nil
+					}
+				}
+			}, func(reason error) {
+				signal.Stop(hup)
+				close(done)
+			})
+		}
+
+		if c.Bool("config-watch") && c.String("config") != "" {
+			done := make(chan struct{})
+
+			gr.Add(func() error {
+				return reload.Watch(c.String("config"), done)
+			}, func(reason error) {
+				close(done)
+			})
+		}
+
+		{
+			ctx, cancel := context.WithCancel(context.Background())
+
+			gr.Add(func() error {
+				return conns.Run(ctx)
+			}, func(reason error) {
+				cancel()
+			})
+		}
+
+		{
+			ctx, cancel := context.WithCancel(context.Background())
+
+			gr.Add(func() error {
+				return routes.Run(ctx)
+			}, func(reason error) {
+				cancel()
+			})
+		}
+
+		{
+			ctx, cancel := context.WithCancel(context.Background())
+
+			gr.Add(func() error {
+				return guard.Run(ctx)
+			}, func(reason error) {
+				cancel()
+			})
+		}
+
+		{
+			ctx, cancel := context.WithCancel(context.Background())
+
+			gr.Add(func() error {
+				return sessions.Run(ctx)
+			}, func(reason error) {
+				cancel()
+			})
+		}
+
+		{
+			ctx, cancel := context.WithCancel(context.Background())
+
+			gr.Add(func() error {
+				return cache.Run(ctx)
+			}, func(reason error) {
+				cancel()
 			})
 		}
 
 		{
 			server := &http.Server{
-				Addr:         cfg.Server.Health,
-				Handler:      router.Status(cfg),
-				ReadTimeout:  5 * time.Second,
-				WriteTimeout: 10 * time.Second,
+				Addr:              cfg.Server.Health,
+				Handler:           router.Status(cfg, conns, dir, sessions, routes, guard, checks),
+				ReadTimeout:       cfg.Server.ReadTimeout,
+				ReadHeaderTimeout: cfg.Server.HeaderTimeout,
+				WriteTimeout:      cfg.Server.WriteTimeout,
+				IdleTimeout:       cfg.Server.IdleTimeout,
 			}
 
 			gr.Add(func() error {
@@ -290,7 +882,7 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 				return server.ListenAndServe()
 			}, func(reason error) {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown)
 				defer cancel()
 
 				if err := server.Shutdown(ctx); err != nil {
@@ -328,10 +920,12 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 			{
 				server := &http.Server{
-					Addr:         httpAddr,
-					Handler:      router.Redirect(cfg),
-					ReadTimeout:  5 * time.Second,
-					WriteTimeout: 10 * time.Second,
+					Addr:              httpAddr,
+					Handler:           router.Redirect(cfg),
+					ReadTimeout:       cfg.Server.ReadTimeout,
+					ReadHeaderTimeout: cfg.Server.HeaderTimeout,
+					WriteTimeout:      cfg.Server.WriteTimeout,
+					IdleTimeout:       cfg.Server.IdleTimeout,
 				}
 
 				gr.Add(func() error {
@@ -341,7 +935,7 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 					return server.ListenAndServe()
 				}, func(reason error) {
-					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown)
 					defer cancel()
 
 					if err := server.Shutdown(ctx); err != nil {
@@ -360,10 +954,12 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 			{
 				server := &http.Server{
-					Addr:         httpsAddr,
-					Handler:      router.Load(cfg, proxy),
-					ReadTimeout:  5 * time.Second,
-					WriteTimeout: 10 * time.Second,
+					Addr:              httpsAddr,
+					Handler:           router.Load(cfg, dir, sessions, cache, routes, guard),
+					ReadTimeout:       cfg.Server.ReadTimeout,
+					ReadHeaderTimeout: cfg.Server.HeaderTimeout,
+					WriteTimeout:      cfg.Server.WriteTimeout,
+					IdleTimeout:       cfg.Server.IdleTimeout,
 					TLSConfig: &tls.Config{
 						PreferServerCipherSuites: true,
 						MinVersion:               tls.VersionTLS12,
@@ -380,7 +976,7 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 					return server.ListenAndServeTLS("", "")
 				}, func(reason error) {
-					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown)
 					defer cancel()
 
 					if err := server.Shutdown(ctx); err != nil {
@@ -399,10 +995,7 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 			return gr.Run()
 		} else if cfg.Server.Cert != "" && cfg.Server.Key != "" {
-			cert, err := tls.LoadX509KeyPair(
-				cfg.Server.Cert,
-				cfg.Server.Key,
-			)
+			cert, err := loadKeypair(cfg)
 
 			if err != nil {
 				log.Info().
@@ -412,12 +1005,16 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 				return err
 			}
 
+			certs.Set(cert)
+
 			{
 				server := &http.Server{
-					Addr:         cfg.Server.Public,
-					Handler:      router.Redirect(cfg),
-					ReadTimeout:  5 * time.Second,
-					WriteTimeout: 10 * time.Second,
+					Addr:              cfg.Server.Public,
+					Handler:           router.Redirect(cfg),
+					ReadTimeout:       cfg.Server.ReadTimeout,
+					ReadHeaderTimeout: cfg.Server.HeaderTimeout,
+					WriteTimeout:      cfg.Server.WriteTimeout,
+					IdleTimeout:       cfg.Server.IdleTimeout,
 				}
 
 				gr.Add(func() error {
@@ -427,7 +1024,7 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 					return server.ListenAndServe()
 				}, func(reason error) {
-					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown)
 					defer cancel()
 
 					if err := server.Shutdown(ctx); err != nil {
@@ -446,16 +1043,18 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 			{
 				server := &http.Server{
-					Addr:         cfg.Server.Secure,
-					Handler:      router.Load(cfg, proxy),
-					ReadTimeout:  5 * time.Second,
-					WriteTimeout: 10 * time.Second,
+					Addr:              cfg.Server.Secure,
+					Handler:           router.Load(cfg, dir, sessions, cache, routes, guard),
+					ReadTimeout:       cfg.Server.ReadTimeout,
+					ReadHeaderTimeout: cfg.Server.HeaderTimeout,
+					WriteTimeout:      cfg.Server.WriteTimeout,
+					IdleTimeout:       cfg.Server.IdleTimeout,
 					TLSConfig: &tls.Config{
 						PreferServerCipherSuites: true,
 						MinVersion:               tls.VersionTLS12,
 						CurvePreferences:         curves(cfg),
 						CipherSuites:             ciphers(cfg),
-						Certificates:             []tls.Certificate{cert},
+						GetCertificate:           certs.GetCertificate,
 					},
 				}
 
@@ -466,7 +1065,7 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 					return server.ListenAndServeTLS("", "")
 				}, func(reason error) {
-					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown)
 					defer cancel()
 
 					if err := server.Shutdown(ctx); err != nil {
@@ -488,10 +1087,12 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 		{
 			server := &http.Server{
-				Addr:         cfg.Server.Public,
-				Handler:      router.Load(cfg, proxy),
-				ReadTimeout:  5 * time.Second,
-				WriteTimeout: 10 * time.Second,
+				Addr:              cfg.Server.Public,
+				Handler:           router.Load(cfg, dir, sessions, cache, routes, guard),
+				ReadTimeout:       cfg.Server.ReadTimeout,
+				ReadHeaderTimeout: cfg.Server.HeaderTimeout,
+				WriteTimeout:      cfg.Server.WriteTimeout,
+				IdleTimeout:       cfg.Server.IdleTimeout,
 			}
 
 			gr.Add(func() error {
@@ -501,7 +1102,7 @@ func serverAction(cfg *config.Config) cli.ActionFunc {
 
 				return server.ListenAndServe()
 			}, func(reason error) {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown)
 				defer cancel()
 
 				if err := server.Shutdown(ctx); err != nil {
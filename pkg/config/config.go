@@ -1,58 +1,294 @@
 package config
 
+import (
+	"time"
+)
+
 // Server defines the server configuration.
 type Server struct {
-	Health        string
-	Secure        string
-	Public        string
-	Host          string
-	Root          string
-	Cert          string
-	Key           string
-	AutoCert      bool
-	StrictCurves  bool
-	StrictCiphers bool
-	Templates     string
-	Assets        string
-	Storage       string
+	Health        string        `yaml:"health"`
+	Secure        string        `yaml:"secure"`
+	Public        string        `yaml:"public"`
+	Host          string        `yaml:"host"`
+	Root          string        `yaml:"root"`
+	Cert          string        `yaml:"cert"`
+	Key           string        `yaml:"key"`
+	AutoCert      bool          `yaml:"auto_cert"`
+	StrictCurves  bool          `yaml:"strict_curves"`
+	StrictCiphers bool          `yaml:"strict_ciphers"`
+	Templates     string        `yaml:"templates"`
+	Assets        string        `yaml:"assets"`
+	Storage       string        `yaml:"storage"`
+	Proxies       []string      `yaml:"proxies"`
+	Drain         time.Duration `yaml:"drain"`
+	Shutdown      time.Duration `yaml:"shutdown"`
+	ReadTimeout   time.Duration `yaml:"read_timeout"`
+	HeaderTimeout time.Duration `yaml:"header_timeout"`
+	WriteTimeout  time.Duration `yaml:"write_timeout"`
+	IdleTimeout   time.Duration `yaml:"idle_timeout"`
 }
 
 // Logs defines the logging configuration.
 type Logs struct {
-	Level   string
-	Colored bool
-	Pretty  bool
+	Level   string `yaml:"level"`
+	Colored bool   `yaml:"colored"`
+	Pretty  bool   `yaml:"pretty"`
+}
+
+// Header defines the mapping of an LDAP attribute to a proxy header.
+type Header struct {
+	Attribute string `yaml:"attribute"`
+	Name      string `yaml:"name"`
+}
+
+// Probe defines the active health checks of the upstream endpoints, the
+// checks are disabled without a path.
+type Probe struct {
+	Path      string        `yaml:"path"`
+	Interval  time.Duration `yaml:"interval"`
+	Timeout   time.Duration `yaml:"timeout"`
+	Threshold int           `yaml:"threshold"`
+}
+
+// Breaker defines the circuit breaker of an upstream pool, the breaker is
+// disabled without an expression.
+type Breaker struct {
+	Expression string        `yaml:"expression"`
+	Fallback   time.Duration `yaml:"fallback"`
+	Recovery   time.Duration `yaml:"recovery"`
+}
+
+// Balancer defines the load balancing of an upstream pool. Sticky binds the
+// authenticated users to an endpoint, rebalance adjusts the weights based on
+// the observed error rates.
+type Balancer struct {
+	Sticky    bool `yaml:"sticky"`
+	Rebalance bool `yaml:"rebalance"`
+}
+
+// Stream defines the handling of upgraded connections like websockets, event
+// streams and requests to the streaming paths. Streams bypass the buffer and
+// they are closed after the lifetime if defined. Upgrades and stream paths
+// bypass the request timeout, event streams only with a lifetime.
+type Stream struct {
+	Lifetime time.Duration `yaml:"lifetime"`
+	Flush    time.Duration `yaml:"flush"`
+	Paths    []string      `yaml:"paths"`
+}
+
+// Limits defines the body limits and timeouts of an upstream pool, zero
+// values disable the limits. The timeout applies to the whole request except
+// for streams.
+type Limits struct {
+	RequestBody   int64         `yaml:"request_body"`
+	ResponseBody  int64         `yaml:"response_body"`
+	Timeout       time.Duration `yaml:"timeout"`
+	DialTimeout   time.Duration `yaml:"dial_timeout"`
+	HeaderTimeout time.Duration `yaml:"header_timeout"`
+}
+
+// Basic defines the HTTP basic authentication against LDAP for clients which
+// can't use the login form, successful authentications are cached for the
+// TTL.
+type Basic struct {
+	Enabled bool          `yaml:"enabled"`
+	Realm   string        `yaml:"realm"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// Route defines a virtual host or path prefix with a separate upstream pool,
+// missing user header, headers, access, probe, breaker, balancer, stream,
+// limits or basic settings are inherited from the global configuration.
+type Route struct {
+	Name       string     `yaml:"name"`
+	Host       string     `yaml:"host"`
+	Path       string     `yaml:"path"`
+	StripPath  bool       `yaml:"strip_path"`
+	Endpoints  []Endpoint `yaml:"endpoints"`
+	UserHeader string     `yaml:"user_header"`
+	Headers    []Header   `yaml:"headers"`
+	Access     *Access    `yaml:"access"`
+	Probe      *Probe     `yaml:"probe"`
+	Breaker    *Breaker   `yaml:"breaker"`
+	Balancer   *Balancer  `yaml:"balancer"`
+	Stream     *Stream    `yaml:"stream"`
+	Limits     *Limits    `yaml:"limits"`
+	Basic      *Basic     `yaml:"basic"`
 }
 
 // Proxy defines the proxy configuration.
 type Proxy struct {
-	Title      string
-	Endpoints  []string
-	UserHeader string
+	Title      string     `yaml:"title"`
+	Endpoints  []Endpoint `yaml:"endpoints"`
+	UserHeader string     `yaml:"user_header"`
+	Headers    []Header   `yaml:"headers"`
+	Routes     []Route    `yaml:"routes"`
+	Probe      Probe      `yaml:"probe"`
+	Breaker    Breaker    `yaml:"breaker"`
+	Balancer   Balancer   `yaml:"balancer"`
+	Stream     Stream     `yaml:"stream"`
+	Limits     Limits     `yaml:"limits"`
+	Basic      Basic      `yaml:"basic"`
+}
+
+const (
+	// GroupModeMemberOf reads the memberOf attribute of users and groups.
+	GroupModeMemberOf = "memberof"
+
+	// GroupModeInChain uses the LDAP_MATCHING_RULE_IN_CHAIN of Active Directory.
+	GroupModeInChain = "inchain"
+
+	// GroupModeMember searches for groupOfNames containing the user.
+	GroupModeMember = "member"
+
+	// GroupModeUniqueMember searches for groupOfUniqueNames containing the user.
+	GroupModeUniqueMember = "uniquemember"
+
+	// GroupModePosix searches for posixGroup containing the username.
+	GroupModePosix = "posix"
+)
+
+// GroupModes lists the supported modes to resolve the groups of a user.
+var GroupModes = []string{
+	GroupModeMemberOf,
+	GroupModeInChain,
+	GroupModeMember,
+	GroupModeUniqueMember,
+	GroupModePosix,
 }
 
 // LDAP defines the ldap configuration.
 type LDAP struct {
-	Addr         string
-	BindUsername string
-	BindPassword string
-	BaseDN       string
-	FilterDN     string
-	UserAttr     string
-	UserHeader   string
-	MailAttr     string
-	MailHeader   string
+	Servers      []string      `yaml:"servers"`
+	PoolSize     int           `yaml:"pool_size"`
+	Timeout      time.Duration `yaml:"timeout"`
+	Interval     time.Duration `yaml:"interval"`
+	StartTLS     bool          `yaml:"start_tls"`
+	CA           string        `yaml:"ca"`
+	Cert         string        `yaml:"cert"`
+	Key          string        `yaml:"key"`
+	ServerName   string        `yaml:"server_name"`
+	Insecure     bool          `yaml:"insecure"`
+	BindUsername string        `yaml:"bind_username"`
+	BindPassword string        `yaml:"bind_password"`
+	BaseDN       string        `yaml:"base_dn"`
+	FilterDN     string        `yaml:"filter_dn"`
+	UserAttr     string        `yaml:"user_attr"`
+	GroupMode    string        `yaml:"group_mode"`
+	GroupBase    string        `yaml:"group_base"`
+	GroupDepth   int           `yaml:"group_depth"`
+}
+
+// Redis defines the connection to a Redis compatible server.
+type Redis struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Prefix   string `yaml:"prefix"`
+}
+
+// Session defines the session configuration. Sessions expire after the expire
+// duration, sliding sessions get renewed on activity up to the absolute
+// lifetime. The idle timeout applies independently, zero values disable the
+// idle timeout and the lifetime.
+type Session struct {
+	Name       string        `yaml:"name"`
+	Secrets    []string      `yaml:"secrets"`
+	Encryption []string      `yaml:"encryption"`
+	Expire     time.Duration `yaml:"expire"`
+	Idle       time.Duration `yaml:"idle"`
+	Lifetime   time.Duration `yaml:"lifetime"`
+	Sliding    bool          `yaml:"sliding"`
+	Logout     string        `yaml:"logout"`
+	Store      string        `yaml:"store"`
+	Capacity   int           `yaml:"capacity"`
+	Redis      Redis         `yaml:"redis"`
+}
+
+// Lockout defines the brute-force protection of the login. Failed attempts
+// delay further attempts of the same client and username exponentially, the
+// client or username gets locked after reaching the threshold. Zero values
+// disable the backoff or the lockout.
+type Lockout struct {
+	Threshold   int           `yaml:"threshold"`
+	IPThreshold int           `yaml:"ip_threshold"`
+	Duration    time.Duration `yaml:"duration"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+}
+
+// Admin defines the access to the admin API on the status server, requests
+// are either authenticated by the static token or by the credentials of a
+// member of the LDAP group, which is defined by its full DN. The API is
+// disabled if both are empty.
+type Admin struct {
+	Group string `yaml:"group"`
+	Token string `yaml:"token"`
+}
+
+// Rule defines a single access rule.
+type Rule struct {
+	Host    string   `yaml:"host"`
+	Path    string   `yaml:"path"`
+	Regex   string   `yaml:"regex"`
+	Methods []string `yaml:"methods"`
+	Allow   []string `yaml:"allow"`
+	Deny    []string `yaml:"deny"`
+}
+
+// Access defines the access configuration.
+type Access struct {
+	Default string `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Health defines the health check configuration.
+type Health struct {
+	Check    string        `yaml:"check"`
+	Timeout  time.Duration `yaml:"timeout"`
+	Secure   bool          `yaml:"secure"`
+	CA       string        `yaml:"ca"`
+	Insecure bool          `yaml:"insecure"`
 }
 
 // Config defines the general configuration.
 type Config struct {
-	Server Server
-	Logs   Logs
-	Proxy  Proxy
-	LDAP   LDAP
+	Server  Server  `yaml:"server"`
+	Logs    Logs    `yaml:"logs"`
+	Proxy   Proxy   `yaml:"proxy"`
+	LDAP    LDAP    `yaml:"ldap"`
+	Session Session `yaml:"session"`
+	Lockout Lockout `yaml:"lockout"`
+	Admin   Admin   `yaml:"admin"`
+	Access  Access  `yaml:"access"`
+	Health  Health  `yaml:"health"`
 }
 
 // New prepares a new default configuration.
 func New() *Config {
 	return &Config{}
 }
+
+// Attributes returns the distinct LDAP attributes mapped to proxy headers by
+// the global configuration and all routes.
+func (c *Config) Attributes() []string {
+	seen := make(map[string]bool)
+	result := []string{}
+
+	headers := append([]Header{}, c.Proxy.Headers...)
+
+	for _, route := range c.Proxy.Routes {
+		headers = append(headers, route.Headers...)
+	}
+
+	for _, header := range headers {
+		if seen[header.Attribute] {
+			continue
+		}
+
+		seen[header.Attribute] = true
+		result = append(result, header.Attribute)
+	}
+
+	return result
+}
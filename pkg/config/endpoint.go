@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Endpoint defines a single upstream endpoint with its balancing weight.
+type Endpoint struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// ParseEndpoint parses an endpoint from a plain URL with an optional weight,
+// like http://app:8080;weight=3.
+func ParseEndpoint(val string) (Endpoint, error) {
+	parts := strings.Split(val, ";")
+	endpoint := Endpoint{
+		URL: strings.TrimSpace(parts[0]),
+	}
+
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(part, "=", 2)
+
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "weight" {
+			return endpoint, fmt.Errorf("invalid endpoint option: %s", part)
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+
+		if err != nil {
+			return endpoint, fmt.Errorf("invalid endpoint weight: %s", kv[1])
+		}
+
+		endpoint.Weight = weight
+	}
+
+	return endpoint, nil
+}
+
+// UnmarshalYAML accepts endpoints as plain strings or as mappings.
+func (e *Endpoint) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var val string
+
+	if err := unmarshal(&val); err == nil {
+		parsed, err := ParseEndpoint(val)
+
+		if err != nil {
+			return err
+		}
+
+		*e = parsed
+		return nil
+	}
+
+	type plain Endpoint
+	return unmarshal((*plain)(e))
+}
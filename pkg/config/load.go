@@ -0,0 +1,58 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Load reads the configuration file into the given configuration, the format
+// gets detected by the file extension. Values which are not part of the file
+// keep their current value.
+func Load(file string, cfg *Config) error {
+	content, err := ioutil.ReadFile(file)
+
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yml", ".yaml":
+		return yaml.UnmarshalStrict(content, cfg)
+	case ".json":
+		raw := make(map[string]interface{})
+
+		if err := json.Unmarshal(content, &raw); err != nil {
+			return err
+		}
+
+		return convert(raw, cfg)
+	case ".toml":
+		raw := make(map[string]interface{})
+
+		if _, err := toml.Decode(string(content), &raw); err != nil {
+			return err
+		}
+
+		return convert(raw, cfg)
+	default:
+		return fmt.Errorf("unsupported config format: %s", filepath.Ext(file))
+	}
+}
+
+// convert passes generic values through the YAML decoder, this way all
+// formats share the same keys and parsing of durations.
+func convert(raw map[string]interface{}, cfg *Config) error {
+	content, err := yaml.Marshal(raw)
+
+	if err != nil {
+		return err
+	}
+
+	return yaml.UnmarshalStrict(content, cfg)
+}
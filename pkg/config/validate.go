@@ -0,0 +1,345 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Errors collects multiple validation errors.
+type Errors []error
+
+// Error implements the error interface.
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// Validate checks the configuration and reports all errors at once.
+func (c *Config) Validate() error {
+	errs := Errors{}
+
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Server.Root == "" || !strings.HasPrefix(c.Server.Root, "/") {
+		add("server root must be an absolute path")
+	}
+
+	if (c.Server.Cert == "") != (c.Server.Key == "") {
+		add("server cert and key must be defined together")
+	}
+
+	for _, proxy := range c.Server.Proxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			add("server proxy %s must be an address or a CIDR range", proxy)
+		}
+	}
+
+	if c.Server.Drain < 0 {
+		add("server drain period must not be negative")
+	}
+
+	if c.Server.Shutdown <= 0 {
+		add("server shutdown timeout must be positive")
+	}
+
+	if c.Server.ReadTimeout < 0 || c.Server.HeaderTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		add("server timeouts must not be negative")
+	}
+
+	if c.Proxy.UserHeader == "" {
+		add("proxy user header must be defined")
+	}
+
+	validateUpstream(c.Proxy.Endpoints, c.Proxy.Headers, "proxy", add)
+	validateProbe(c.Proxy.Probe, "proxy probe", add)
+
+	if c.Proxy.Probe.Interval <= 0 || c.Proxy.Probe.Timeout <= 0 || c.Proxy.Probe.Threshold <= 0 {
+		add("proxy probe interval, timeout and threshold must be positive")
+	}
+
+	validateBreaker(c.Proxy.Breaker, "proxy breaker", add)
+	validateStream(c.Proxy.Stream, "proxy stream", add)
+	validateLimits(c.Proxy.Limits, "proxy limits", add)
+	validateBasic(c.Proxy.Basic, "proxy basic", add)
+
+	names := map[string]bool{
+		"default": true,
+	}
+
+	for i, route := range c.Proxy.Routes {
+		prefix := fmt.Sprintf("proxy route %d", i)
+
+		if route.Host == "" && route.Path == "" {
+			add("%s requires a host or a path", prefix)
+		}
+
+		if route.Path != "" && !strings.HasPrefix(route.Path, "/") {
+			add("%s path must be an absolute path", prefix)
+		}
+
+		if len(route.Endpoints) == 0 {
+			add("%s requires at least one endpoint", prefix)
+		}
+
+		name := route.Name
+
+		if name == "" {
+			name = route.Host + route.Path
+		}
+
+		if names[name] {
+			add("%s name %q is already used", prefix, name)
+		}
+
+		names[name] = true
+
+		validateUpstream(route.Endpoints, route.Headers, prefix, add)
+
+		if route.Access != nil {
+			validateAccess(*route.Access, prefix+" access", add)
+		}
+
+		if route.Probe != nil {
+			validateProbe(*route.Probe, prefix+" probe", add)
+		}
+
+		if route.Breaker != nil {
+			validateBreaker(*route.Breaker, prefix+" breaker", add)
+		}
+
+		if route.Stream != nil {
+			validateStream(*route.Stream, prefix+" stream", add)
+		}
+
+		if route.Limits != nil {
+			validateLimits(*route.Limits, prefix+" limits", add)
+		}
+
+		if route.Basic != nil {
+			validateBasic(*route.Basic, prefix+" basic", add)
+		}
+	}
+
+	if len(c.LDAP.Servers) == 0 {
+		add("at least one ldap server must be defined")
+	}
+
+	if c.LDAP.BaseDN == "" {
+		add("ldap base dn must be defined")
+	}
+
+	if !strings.Contains(c.LDAP.FilterDN, "{login}") {
+		add("ldap filter must contain the {login} placeholder")
+	}
+
+	if c.LDAP.PoolSize < 0 {
+		add("ldap pool size must not be negative")
+	}
+
+	if c.LDAP.Timeout <= 0 {
+		add("ldap timeout must be positive")
+	}
+
+	if c.LDAP.Interval <= 0 {
+		add("ldap interval must be positive")
+	}
+
+	if (c.LDAP.Cert == "") != (c.LDAP.Key == "") {
+		add("ldap cert and key must be defined together")
+	}
+
+	if c.LDAP.GroupMode != "" && !contains(GroupModes, strings.ToLower(c.LDAP.GroupMode)) {
+		add("ldap group mode %q is not supported", c.LDAP.GroupMode)
+	}
+
+	if c.LDAP.GroupDepth < 0 {
+		add("ldap group depth must not be negative")
+	}
+
+	if c.Session.Name == "" {
+		add("session name must be defined")
+	}
+
+	if c.Session.Expire <= 0 {
+		add("session expire must be positive")
+	}
+
+	if c.Session.Idle < 0 || c.Session.Lifetime < 0 {
+		add("session idle timeout and lifetime must not be negative")
+	}
+
+	if c.Session.Lifetime > 0 && c.Session.Lifetime < c.Session.Expire {
+		add("session lifetime must not be shorter than the expire")
+	}
+
+	switch c.Session.Store {
+	case "", "memory":
+		if c.Session.Capacity < 0 {
+			add("session capacity must not be negative")
+		}
+	case "file":
+		if c.Server.Storage == "" {
+			add("session file store requires a server storage")
+		}
+	case "redis":
+		if c.Session.Redis.Addr == "" {
+			add("session redis store requires an address")
+		}
+	default:
+		add("session store %q is not supported", c.Session.Store)
+	}
+
+	// Generated secrets don't survive a restart and differ between instances,
+	// which breaks the sessions of the persistent and shared stores.
+	if (c.Session.Store == "file" || c.Session.Store == "redis") && len(c.Session.Secrets) == 0 {
+		add("session %s store requires a session secret", c.Session.Store)
+	}
+
+	if c.Session.Logout != "" {
+		if parsed, err := url.Parse(c.Session.Logout); err != nil || (parsed.Host == "" && !strings.HasPrefix(c.Session.Logout, "/")) {
+			add("session logout must be an absolute path or url")
+		}
+	}
+
+	if len(c.Session.Encryption) > len(c.Session.Secrets) {
+		add("every session encryption key requires a matching secret")
+	}
+
+	for i, key := range c.Session.Encryption {
+		switch len(key) {
+		case 0, 16, 24, 32:
+		default:
+			add("session encryption key %d must have a length of 16, 24 or 32 bytes", i)
+		}
+	}
+
+	if c.Lockout.Threshold < 0 || c.Lockout.IPThreshold < 0 {
+		add("lockout thresholds must not be negative")
+	}
+
+	if c.Lockout.Duration <= 0 {
+		add("lockout duration must be positive")
+	}
+
+	if c.Lockout.Backoff < 0 || c.Lockout.MaxBackoff < c.Lockout.Backoff {
+		add("lockout backoff must not be negative or exceed the maximum backoff")
+	}
+
+	if c.Admin.Group != "" && !strings.Contains(c.Admin.Group, "=") {
+		add("admin group must be a full DN")
+	}
+
+	if c.Admin.Token != "" && len(c.Admin.Token) < 16 {
+		add("admin token must have a length of at least 16 characters")
+	}
+
+	validateAccess(c.Access, "access", add)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateUpstream(endpoints []Endpoint, headers []Header, prefix string, add func(string, ...interface{})) {
+	for _, endpoint := range endpoints {
+		if parsed, err := url.Parse(endpoint.URL); err != nil || parsed.Host == "" {
+			add("%s endpoint %q is not a valid url", prefix, endpoint.URL)
+		}
+
+		if endpoint.Weight < 0 {
+			add("%s endpoint %q must not have a negative weight", prefix, endpoint.URL)
+		}
+	}
+
+	for i, header := range headers {
+		if header.Attribute == "" || header.Name == "" {
+			add("%s header %d requires an attribute and a name", prefix, i)
+		}
+	}
+}
+
+func validateProbe(probe Probe, prefix string, add func(string, ...interface{})) {
+	if probe.Path != "" && !strings.HasPrefix(probe.Path, "/") {
+		add("%s path must be an absolute path", prefix)
+	}
+
+	if probe.Interval < 0 || probe.Timeout < 0 || probe.Threshold < 0 {
+		add("%s interval, timeout and threshold must not be negative", prefix)
+	}
+}
+
+func validateBreaker(breaker Breaker, prefix string, add func(string, ...interface{})) {
+	if breaker.Fallback < 0 || breaker.Recovery < 0 {
+		add("%s fallback and recovery must not be negative", prefix)
+	}
+}
+
+func validateStream(stream Stream, prefix string, add func(string, ...interface{})) {
+	if stream.Lifetime < 0 || stream.Flush < 0 {
+		add("%s lifetime and flush must not be negative", prefix)
+	}
+
+	for _, path := range stream.Paths {
+		if !strings.HasPrefix(path, "/") {
+			add("%s path %q must be an absolute path", prefix, path)
+		}
+	}
+}
+
+func validateLimits(limits Limits, prefix string, add func(string, ...interface{})) {
+	if limits.RequestBody < 0 || limits.ResponseBody < 0 {
+		add("%s body sizes must not be negative", prefix)
+	}
+
+	if limits.Timeout < 0 || limits.DialTimeout < 0 || limits.HeaderTimeout < 0 {
+		add("%s timeouts must not be negative", prefix)
+	}
+}
+
+func validateBasic(basic Basic, prefix string, add func(string, ...interface{})) {
+	if basic.TTL < 0 {
+		add("%s ttl must not be negative", prefix)
+	}
+
+	if strings.ContainsAny(basic.Realm, "\"\r\n") {
+		add("%s realm must not contain quotes or line breaks", prefix)
+	}
+}
+
+func validateAccess(access Access, prefix string, add func(string, ...interface{})) {
+	switch strings.ToLower(access.Default) {
+	case "", "allow", "deny":
+	default:
+		add("%s default %q must be allow or deny", prefix, access.Default)
+	}
+
+	for i, rule := range access.Rules {
+		if rule.Regex != "" {
+			if _, err := regexp.Compile(rule.Regex); err != nil {
+				add("%s rule %d has an invalid regex: %s", prefix, i, err)
+			}
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
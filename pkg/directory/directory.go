@@ -0,0 +1,186 @@
+package directory
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/pool"
+	"gopkg.in/ldap.v2"
+)
+
+var (
+	// ErrInvalidCredentials gets returned if the username or password is wrong.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+
+	// ErrUserNotFound gets returned if the filter doesn't match any user.
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrUserAmbiguous gets returned if the filter matches multiple users.
+	ErrUserAmbiguous = errors.New("user is ambiguous")
+)
+
+// User represents a user which have been found within the directory.
+type User struct {
+	DN         string
+	Login      string
+	Groups     []string
+	Attributes map[string][]string
+}
+
+// Directory handles the communication with the LDAP server.
+type Directory struct {
+	cfg  *config.Config
+	pool *pool.Pool
+}
+
+// New initializes a new directory for the given configuration.
+func New(cfg *config.Config, p *pool.Pool) *Directory {
+	return &Directory{
+		cfg:  cfg,
+		pool: p,
+	}
+}
+
+// Authenticate searches for the user with the service account and binds with
+// the found DN and the given password afterwards.
+func (d *Directory) Authenticate(username, password string) (*User, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.pool.Get()
+
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to get ldap connection")
+
+		return nil, err
+	}
+
+	user, err := d.authenticate(conn, username, password)
+
+	switch err {
+	case nil, ErrInvalidCredentials, ErrUserNotFound, ErrUserAmbiguous:
+		d.pool.Put(conn)
+	default:
+		d.pool.Discard(conn)
+	}
+
+	return user, err
+}
+
+func (d *Directory) authenticate(conn *pool.Conn, username, password string) (*User, error) {
+	user, err := d.search(conn, username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(user.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+
+		return nil, err
+	}
+
+	if err := conn.Reset(); err != nil {
+		return nil, err
+	}
+
+	groups, err := d.groups(conn, user)
+
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("username", username).
+			Msg("failed to resolve groups")
+
+		return nil, err
+	}
+
+	user.Groups = groups
+
+	return user, nil
+}
+
+func (d *Directory) search(conn *pool.Conn, username string) (*User, error) {
+	req := ldap.NewSearchRequest(
+		d.cfg.LDAP.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		0,
+		false,
+		d.filter(username),
+		d.attributes(),
+		nil,
+	)
+
+	res, err := conn.Search(req)
+
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrUserAmbiguous
+		}
+
+		log.Error().
+			Err(err).
+			Str("username", username).
+			Msg("failed to search for user")
+
+		return nil, err
+	}
+
+	switch len(res.Entries) {
+	case 0:
+		return nil, ErrUserNotFound
+	case 1:
+		return d.user(res.Entries[0], username), nil
+	default:
+		return nil, ErrUserAmbiguous
+	}
+}
+
+// filter builds the search filter for the user, the username gets escaped to
+// prevent filter injections.
+func (d *Directory) filter(username string) string {
+	return strings.Replace(
+		d.cfg.LDAP.FilterDN,
+		"{login}",
+		ldap.EscapeFilter(username),
+		-1,
+	)
+}
+
+func (d *Directory) user(entry *ldap.Entry, username string) *User {
+	user := &User{
+		DN:         entry.DN,
+		Login:      entry.GetAttributeValue(d.cfg.LDAP.UserAttr),
+		Groups:     entry.GetAttributeValues("memberOf"),
+		Attributes: make(map[string][]string, len(entry.Attributes)),
+	}
+
+	if user.Login == "" {
+		user.Login = username
+	}
+
+	for _, attr := range entry.Attributes {
+		user.Attributes[attr.Name] = attr.Values
+	}
+
+	return user
+}
+
+func (d *Directory) attributes() []string {
+	attrs := []string{
+		"dn",
+		d.cfg.LDAP.UserAttr,
+		"memberOf",
+	}
+
+	return append(attrs, d.cfg.Attributes()...)
+}
@@ -0,0 +1,72 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+func TestFilter(t *testing.T) {
+	cfg := config.New()
+	cfg.LDAP.FilterDN = "(&(objectClass=inetOrgPerson)(uid={login}))"
+
+	d := New(cfg, nil)
+
+	tests := []struct {
+		name     string
+		username string
+		want     string
+	}{
+		{
+			name:     "plain",
+			username: "alice",
+			want:     "(&(objectClass=inetOrgPerson)(uid=alice))",
+		},
+		{
+			name:     "wildcard",
+			username: "*",
+			want:     `(&(objectClass=inetOrgPerson)(uid=\2a))`,
+		},
+		{
+			name:     "injection",
+			username: "alice)(|(uid=*",
+			want:     `(&(objectClass=inetOrgPerson)(uid=alice\29\28|\28uid=\2a))`,
+		},
+		{
+			name:     "backslash",
+			username: `alice\2a`,
+			want:     `(&(objectClass=inetOrgPerson)(uid=alice\5c2a))`,
+		},
+		{
+			name:     "null",
+			username: "alice\x00",
+			want:     `(&(objectClass=inetOrgPerson)(uid=alice\00))`,
+		},
+		{
+			name:     "placeholder",
+			username: "{login}",
+			want:     "(&(objectClass=inetOrgPerson)(uid={login}))",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.filter(tt.username); got != tt.want {
+				t.Errorf("filter(%q) = %q, want %q", tt.username, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMultiple(t *testing.T) {
+	cfg := config.New()
+	cfg.LDAP.FilterDN = "(|(uid={login})(mail={login}))"
+
+	d := New(cfg, nil)
+
+	want := `(|(uid=a\2a)(mail=a\2a))`
+
+	if got := d.filter("a*"); got != want {
+		t.Errorf("filter(%q) = %q, want %q", "a*", got, want)
+	}
+}
@@ -0,0 +1,170 @@
+package directory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/pool"
+	"gopkg.in/ldap.v2"
+)
+
+// The group modes are defined by the config package, which validates them.
+const (
+	// GroupModeMemberOf reads the memberOf attribute of users and groups.
+	GroupModeMemberOf = config.GroupModeMemberOf
+
+	// GroupModeInChain uses the LDAP_MATCHING_RULE_IN_CHAIN of Active Directory.
+	GroupModeInChain = config.GroupModeInChain
+
+	// GroupModeMember searches for groupOfNames containing the user.
+	GroupModeMember = config.GroupModeMember
+
+	// GroupModeUniqueMember searches for groupOfUniqueNames containing the user.
+	GroupModeUniqueMember = config.GroupModeUniqueMember
+
+	// GroupModePosix searches for posixGroup containing the username.
+	GroupModePosix = config.GroupModePosix
+)
+
+// groups resolves all groups of the user, nested groups are resolved up to the
+// configured depth if the server doesn't resolve them on its own.
+func (d *Directory) groups(conn *pool.Conn, user *User) ([]string, error) {
+	switch strings.ToLower(d.cfg.LDAP.GroupMode) {
+	case "", GroupModeMemberOf:
+		return d.nested(user.Groups, func(dn string) ([]string, error) {
+			return d.memberOf(conn, dn)
+		})
+	case GroupModeInChain:
+		return d.lookup(
+			conn,
+			fmt.Sprintf("(member:1.2.840.113556.1.4.1941:=%s)", ldap.EscapeFilter(user.DN)),
+		)
+	case GroupModeMember:
+		return d.members(conn, user.DN, "groupOfNames", "member")
+	case GroupModeUniqueMember:
+		return d.members(conn, user.DN, "groupOfUniqueNames", "uniqueMember")
+	case GroupModePosix:
+		return d.lookup(
+			conn,
+			fmt.Sprintf("(&(objectClass=posixGroup)(memberUid=%s))", ldap.EscapeFilter(user.Login)),
+		)
+	default:
+		return nil, fmt.Errorf("unknown group mode: %s", d.cfg.LDAP.GroupMode)
+	}
+}
+
+// members searches for groups containing the DN within the given attribute.
+func (d *Directory) members(conn *pool.Conn, dn, class, attr string) ([]string, error) {
+	filter := func(dn string) string {
+		return fmt.Sprintf("(&(objectClass=%s)(%s=%s))", class, attr, ldap.EscapeFilter(dn))
+	}
+
+	direct, err := d.lookup(conn, filter(dn))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.nested(direct, func(dn string) ([]string, error) {
+		return d.lookup(conn, filter(dn))
+	})
+}
+
+// memberOf reads the memberOf attribute of the given group.
+func (d *Directory) memberOf(conn *pool.Conn, dn string) ([]string, error) {
+	res, err := conn.Search(ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"memberOf"},
+		nil,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+
+	for _, entry := range res.Entries {
+		result = append(result, entry.GetAttributeValues("memberOf")...)
+	}
+
+	return result, nil
+}
+
+// nested walks the group hierarchy up to the configured depth, the parents
+// function resolves the direct parents of a group.
+func (d *Directory) nested(direct []string, parents func(string) ([]string, error)) ([]string, error) {
+	seen := make(map[string]bool, len(direct))
+	result := make([]string, 0, len(direct))
+
+	current := direct
+
+	for depth := 0; len(current) > 0; depth++ {
+		next := []string{}
+
+		for _, dn := range current {
+			if seen[strings.ToLower(dn)] {
+				continue
+			}
+
+			seen[strings.ToLower(dn)] = true
+			result = append(result, dn)
+
+			if depth >= d.cfg.LDAP.GroupDepth {
+				continue
+			}
+
+			found, err := parents(dn)
+
+			if err != nil {
+				return nil, err
+			}
+
+			next = append(next, found...)
+		}
+
+		current = next
+	}
+
+	return result, nil
+}
+
+// lookup returns the DNs of all groups matching the filter.
+func (d *Directory) lookup(conn *pool.Conn, filter string) ([]string, error) {
+	base := d.cfg.LDAP.GroupBase
+
+	if base == "" {
+		base = d.cfg.LDAP.BaseDN
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		base,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		filter,
+		[]string{"dn"},
+		nil,
+	))
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(res.Entries))
+
+	for _, entry := range res.Entries {
+		result = append(result, entry.DN)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/directory"
+	"github.com/webhippie/ldap-proxy/pkg/lockout"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+)
+
+// Admin restricts the admin API to requests with the static bearer token or
+// to members of the admin group. Members authenticate by their session
+// cookie or by basic auth credentials which are validated against LDAP.
+func Admin(cfg *config.Config, dir *directory.Directory, sessions *session.Manager, guard *lockout.Guard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token := r.Header.Get("Authorization"); strings.HasPrefix(token, "Bearer ") {
+				if cfg.Admin.Token == "" || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(token, "Bearer ")), []byte(cfg.Admin.Token)) != 1 {
+					hlog.FromRequest(r).Warn().
+						Msg("rejected invalid admin token")
+
+					adminError(w, http.StatusUnauthorized, "invalid token")
+					return
+				}
+
+				next.ServeHTTP(w, r.WithContext(session.NewContext(r.Context(), &session.Session{
+					User: "token",
+				})))
+
+				return
+			}
+
+			if cfg.Admin.Group == "" {
+				adminError(w, http.StatusUnauthorized, "missing token")
+				return
+			}
+
+			s, status, msg := adminUser(dir, sessions, guard, r)
+
+			if s == nil {
+				if status == http.StatusUnauthorized {
+					w.Header().Set("WWW-Authenticate", `Basic realm="Admin", charset="UTF-8"`)
+				}
+
+				adminError(w, status, msg)
+				return
+			}
+
+			if !adminMember(s.Groups, cfg.Admin.Group) {
+				hlog.FromRequest(r).Warn().
+					Str("username", s.User).
+					Msg("rejected admin access without admin group")
+
+				adminError(w, http.StatusForbidden, "not a member of the admin group")
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(session.NewContext(r.Context(), s)))
+		})
+	}
+}
+
+// adminUser authenticates the user by the basic auth credentials or the
+// session cookie. The status code and message describe the failure.
+func adminUser(dir *directory.Directory, sessions *session.Manager, guard *lockout.Guard, r *http.Request) (*session.Session, int, string) {
+	username, password, ok := r.BasicAuth()
+
+	if !ok {
+		s, err := sessions.Read(r)
+
+		if err != nil {
+			return nil, http.StatusUnauthorized, "missing credentials"
+		}
+
+		return s, 0, ""
+	}
+
+	if _, err := guard.Check(r, username); err != nil {
+		hlog.FromRequest(r).Warn().
+			Err(err).
+			Str("username", username).
+			Msg("rejected admin authentication")
+
+		return nil, http.StatusTooManyRequests, "too many failed attempts"
+	}
+
+	user, err := dir.Authenticate(username, password)
+
+	switch err {
+	case nil:
+		guard.Succeed(r, username)
+	case directory.ErrInvalidCredentials, directory.ErrUserNotFound, directory.ErrUserAmbiguous:
+		guard.Fail(r, username)
+
+		hlog.FromRequest(r).Info().
+			Err(err).
+			Str("username", username).
+			Msg("failed to authenticate admin")
+
+		return nil, http.StatusUnauthorized, "wrong username or password"
+	default:
+		guard.Release(r, username)
+
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Str("username", username).
+			Msg("failed to authenticate admin")
+
+		return nil, http.StatusServiceUnavailable, "authentication is currently unavailable"
+	}
+
+	return &session.Session{
+		User:   user.Login,
+		DN:     user.DN,
+		Groups: user.Groups,
+	}, 0, ""
+}
+
+// adminMember checks if the admin group is part of the groups. Other than the
+// access rules it requires the full DN, a common name could match a group of
+// another branch of the directory.
+func adminMember(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(strings.TrimSpace(g), strings.TrimSpace(group)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adminError writes an error of the admin API as JSON.
+func adminError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": msg,
+	})
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"testing"
+)
+
+func TestAdminMember(t *testing.T) {
+	group := "cn=admins,ou=groups,dc=example,dc=com"
+
+	tests := []struct {
+		name   string
+		groups []string
+		want   bool
+	}{
+		{"exact", []string{"cn=users,ou=groups,dc=example,dc=com", group}, true},
+		{"case", []string{"CN=Admins,OU=Groups,DC=example,DC=com"}, true},
+		{"common name", []string{"admins"}, false},
+		{"other branch", []string{"cn=admins,ou=guests,dc=example,dc=com"}, false},
+		{"prefix", []string{"cn=admins,ou=groups,dc=example"}, false},
+		{"suffix", []string{"cn=admins,ou=groups,dc=example,dc=com,dc=evil"}, false},
+		{"none", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := adminMember(tt.groups, group); got != tt.want {
+				t.Errorf("adminMember(%v) = %v, want %v", tt.groups, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,23 +1,125 @@
 package handler
 
 import (
+	"math"
 	"net/http"
-	"path"
+	"strconv"
 
+	"github.com/rs/zerolog/hlog"
 	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/directory"
+	"github.com/webhippie/ldap-proxy/pkg/lockout"
+	"github.com/webhippie/ldap-proxy/pkg/metrics"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
 )
 
-// Auth handles the authentication itself against LDAP.
-func Auth(cfg *config.Config) http.HandlerFunc {
+// Auth handles the authentication itself against LDAP, repeated failures are
+// throttled and locked by the guard.
+func Auth(cfg *config.Config, dir *directory.Directory, sessions *session.Manager, guard *lockout.Guard, routes *upstream.Table) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		username := r.PostFormValue("username")
+		password := r.PostFormValue("password")
+
+		if err := sessions.Verify(r, r.PostFormValue("csrf")); err != nil {
+			metrics.LoginAttempts.WithLabelValues("invalid_csrf").Inc()
+
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Str("username", username).
+				Msg("rejected login attempt")
+
+			login(cfg, sessions, routes, w, r, http.StatusForbidden, "The login form has expired, please try again")
+			return
+		}
+
+		if wait, err := guard.Check(r, username); err != nil {
+			outcome := "throttled"
+
+			if err == lockout.ErrLocked {
+				outcome = "locked"
+			}
+
+			metrics.LoginAttempts.WithLabelValues(outcome).Inc()
+
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Str("username", username).
+				Dur("retry", wait).
+				Msg("rejected login attempt")
+
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			login(cfg, sessions, routes, w, r, http.StatusTooManyRequests, "Too many failed attempts, please try again later")
+			return
+		}
+
+		user, err := dir.Authenticate(username, password)
+
+		switch err {
+		case nil:
+			guard.Succeed(r, username)
+			metrics.LoginAttempts.WithLabelValues("success").Inc()
+
+			hlog.FromRequest(r).Info().
+				Str("username", user.Login).
+				Str("dn", user.DN).
+				Msg("successfully authenticated user")
+		case directory.ErrInvalidCredentials, directory.ErrUserNotFound, directory.ErrUserAmbiguous:
+			guard.Fail(r, username)
+			metrics.LoginAttempts.WithLabelValues("bad_credentials").Inc()
+
+			hlog.FromRequest(r).Info().
+				Err(err).
+				Str("username", username).
+				Msg("failed to authenticate user")
+
+			login(cfg, sessions, routes, w, r, http.StatusUnauthorized, "Wrong username or password")
+			return
+		default:
+			guard.Release(r, username)
+			metrics.LoginAttempts.WithLabelValues("ldap_error").Inc()
+
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("username", username).
+				Msg("failed to authenticate user")
+
+			login(cfg, sessions, routes, w, r, http.StatusServiceUnavailable, "Authentication is currently unavailable")
+			return
+		}
+
+		if err := sessions.Issue(w, r, &session.Session{
+			User:       user.Login,
+			DN:         user.DN,
+			Groups:     user.Groups,
+			Attributes: attributes(cfg, user),
+		}); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("username", user.Login).
+				Msg("failed to issue session")
+
+			login(cfg, sessions, routes, w, r, http.StatusInternalServerError, "Failed to create a session")
+			return
+		}
+
 		http.Redirect(
 			w,
 			r,
-			path.Join(
-				cfg.Server.Root,
-				"login",
-			),
-			http.StatusMovedPermanently,
+			returnTo(cfg, routes, r),
+			http.StatusSeeOther,
 		)
 	}
 }
+
+func attributes(cfg *config.Config, user *directory.User) map[string][]string {
+	result := make(map[string][]string)
+
+	for _, attr := range cfg.Attributes() {
+		if values, ok := user.Attributes[attr]; ok {
+			result[attr] = values
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/directory"
+	"github.com/webhippie/ldap-proxy/pkg/lockout"
+	"github.com/webhippie/ldap-proxy/pkg/metrics"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
+)
+
+// Basic authenticates requests without a session by their basic auth
+// credentials if the matching route allows it. The credentials are removed
+// before the request gets proxied.
+func Basic(cfg *config.Config, dir *directory.Directory, guard *lockout.Guard, cache *session.Cache, routes *upstream.Table) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := session.FromContext(r.Context()); ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			route, ok := routes.Match(r)
+
+			if _, _, auth := r.BasicAuth(); !ok || !auth || !route.Basic.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			s, ok := basic(cfg, dir, guard, cache, route, w, r)
+
+			if !ok {
+				return
+			}
+
+			r.Header.Del("Authorization")
+			next.ServeHTTP(w, r.WithContext(session.NewContext(r.Context(), s)))
+		})
+	}
+}
+
+// basic validates the basic auth credentials against LDAP, positive results
+// are cached for the TTL of the route. The error response is already written
+// if it fails.
+func basic(cfg *config.Config, dir *directory.Directory, guard *lockout.Guard, cache *session.Cache, route *upstream.Route, w http.ResponseWriter, r *http.Request) (*session.Session, bool) {
+	username, password, _ := r.BasicAuth()
+
+	if s, ok := cache.Get(username, password); ok {
+		metrics.BasicAttempts.WithLabelValues("cached").Inc()
+		return s, true
+	}
+
+	if wait, err := guard.Check(r, username); err != nil {
+		outcome := "throttled"
+
+		if err == lockout.ErrLocked {
+			outcome = "locked"
+		}
+
+		metrics.BasicAttempts.WithLabelValues(outcome).Inc()
+
+		hlog.FromRequest(r).Warn().
+			Err(err).
+			Str("username", username).
+			Dur("retry", wait).
+			Msg("rejected basic authentication")
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		failure(cfg, w, http.StatusTooManyRequests, "Too many failed attempts, please try again later")
+		return nil, false
+	}
+
+	user, err := dir.Authenticate(username, password)
+
+	switch err {
+	case nil:
+		guard.Succeed(r, username)
+		metrics.BasicAttempts.WithLabelValues("success").Inc()
+
+		hlog.FromRequest(r).Info().
+			Str("username", user.Login).
+			Str("dn", user.DN).
+			Msg("successfully authenticated user by basic auth")
+	case directory.ErrInvalidCredentials, directory.ErrUserNotFound, directory.ErrUserAmbiguous:
+		guard.Fail(r, username)
+		metrics.BasicAttempts.WithLabelValues("bad_credentials").Inc()
+
+		hlog.FromRequest(r).Info().
+			Err(err).
+			Str("username", username).
+			Msg("failed to authenticate user by basic auth")
+
+		challenge(route, w)
+		failure(cfg, w, http.StatusUnauthorized, "Wrong username or password")
+		return nil, false
+	default:
+		guard.Release(r, username)
+		metrics.BasicAttempts.WithLabelValues("ldap_error").Inc()
+
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Str("username", username).
+			Msg("failed to authenticate user by basic auth")
+
+		failure(cfg, w, http.StatusServiceUnavailable, "Authentication is currently unavailable")
+		return nil, false
+	}
+
+	s := &session.Session{
+		User:       user.Login,
+		DN:         user.DN,
+		Groups:     user.Groups,
+		Attributes: attributes(cfg, user),
+		Expires:    time.Now().Add(cfg.Session.Expire),
+	}
+
+	if route.Basic.TTL > 0 {
+		cache.Set(username, password, s, route.Basic.TTL)
+	}
+
+	return s, true
+}
+
+// challenge asks the client for basic auth credentials.
+func challenge(route *upstream.Route, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, route.Basic.Realm))
+}
+
+// browser detects clients which are able to use the login form.
+func browser(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+// Forbidden displays an error page if access has been denied.
+func Forbidden(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		failure(cfg, w, http.StatusForbidden, "You are not allowed to access this resource")
+	}
+}
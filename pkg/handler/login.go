@@ -2,29 +2,89 @@ package handler
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
-	"github.com/rs/zerolog/log"
-	"github.com/webhippie/fail"
+	"github.com/rs/zerolog/hlog"
 	"github.com/webhippie/ldap-proxy/pkg/config"
-	"github.com/webhippie/ldap-proxy/pkg/templates"
+	"github.com/webhippie/ldap-proxy/pkg/policy"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
 )
 
 // Login displays the login form for authentication.
-func Login(cfg *config.Config) http.HandlerFunc {
+func Login(cfg *config.Config, sessions *session.Manager, routes *upstream.Table) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		vars := map[string]string{
-			"Title": cfg.Proxy.Title,
-			"Root":  cfg.Server.Root,
-			"Error": "",
-		}
+		login(cfg, sessions, routes, w, r, http.StatusOK, "")
+	}
+}
+
+func login(cfg *config.Config, sessions *session.Manager, routes *upstream.Table, w http.ResponseWriter, r *http.Request, status int, msg string) {
+	token, err := sessions.Token(w, r)
+
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msg("failed to generate csrf token")
+
+		failure(cfg, w, http.StatusInternalServerError, "Failed to prepare the login form")
+		return
+	}
 
-		if err := templates.Load(cfg).ExecuteTemplate(w, "login.tmpl", vars); err != nil {
-			log.Warn().
-				Err(err).
-				Msg("failed to process login template")
+	render(cfg, w, status, "login.tmpl", map[string]string{
+		"Error":    msg,
+		"CSRF":     token,
+		"ReturnTo": returnTo(cfg, routes, r),
+	})
+}
+
+// returnTo returns the target to redirect to after the login. Only absolute
+// paths and URLs of the configured hosts are allowed to prevent open
+// redirects, everything else falls back to the root.
+func returnTo(cfg *config.Config, routes *upstream.Table, r *http.Request) string {
+	target := r.FormValue("return_to")
+
+	if target == "" {
+		return "/"
+	}
+
+	parsed, err := url.Parse(target)
+
+	if err == nil && allowedTarget(cfg, routes, parsed, target) {
+		return target
+	}
 
-			fail.ErrorPlain(w, fail.Cause(err).Unexpected())
-			return
+	hlog.FromRequest(r).Warn().
+		Str("return_to", target).
+		Msg("rejected redirect target")
+
+	return "/"
+}
+
+func allowedTarget(cfg *config.Config, routes *upstream.Table, parsed *url.URL, target string) bool {
+	if strings.Contains(target, "\\") {
+		return false
+	}
+
+	if parsed.Scheme == "" && parsed.Host == "" {
+		return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//")
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return false
+	}
+
+	if server, err := url.Parse(cfg.Server.Host); err == nil && server.Host != "" {
+		if policy.MatchHost(strings.ToLower(server.Hostname()), parsed.Host) {
+			return true
+		}
+	}
+
+	for _, host := range routes.Hosts() {
+		if policy.MatchHost(host, parsed.Host) {
+			return true
 		}
 	}
+
+	return false
 }
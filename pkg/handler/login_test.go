@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
+)
+
+func TestReturnTo(t *testing.T) {
+	cfg := config.New()
+	cfg.Server.Host = "https://login.example.com"
+	cfg.Proxy.Endpoints = []config.Endpoint{
+		{URL: "http://127.0.0.1:8080"},
+	}
+	cfg.Proxy.Routes = []config.Route{
+		{
+			Name: "app",
+			Host: "app.example.com",
+			Endpoints: []config.Endpoint{
+				{URL: "http://127.0.0.1:8081"},
+			},
+		},
+		{
+			Name: "wildcard",
+			Host: "*.apps.example.com",
+			Endpoints: []config.Endpoint{
+				{URL: "http://127.0.0.1:8082"},
+			},
+		},
+	}
+
+	routes, err := upstream.New(cfg, func(w http.ResponseWriter, r *http.Request, status int, msg string) {})
+
+	if err != nil {
+		t.Fatalf("failed to build routes: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{"empty", "", "/"},
+		{"relative", "/dashboard?tab=1", "/dashboard?tab=1"},
+		{"relative without slash", "dashboard", "/"},
+		{"protocol relative", "//evil.com", "/"},
+		{"protocol relative path", "//evil.com/path", "/"},
+		{"backslash", `/\evil.com`, "/"},
+		{"backslashes", `\\evil.com`, "/"},
+		{"scheme backslash", `https:\\evil.com`, "/"},
+		{"javascript", "javascript:alert(1)", "/"},
+		{"data", "data:text/html,foo", "/"},
+		{"foreign host", "https://evil.com/", "/"},
+		{"suffix host", "https://app.example.com.evil.com/", "/"},
+		{"userinfo", "https://app.example.com@evil.com/", "/"},
+		{"server host", "https://login.example.com/profile", "https://login.example.com/profile"},
+		{"route host", "https://app.example.com/x", "https://app.example.com/x"},
+		{"route host port", "http://APP.example.com:8443/x", "http://APP.example.com:8443/x"},
+		{"wildcard host", "https://one.apps.example.com/", "https://one.apps.example.com/"},
+		{"wildcard apex", "https://apps.example.com/", "/"},
+		{"ftp", "ftp://app.example.com/", "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/login?return_to="+url.QueryEscape(tt.target), nil)
+
+			if got := returnTo(cfg, routes, r); got != tt.want {
+				t.Errorf("returnTo(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
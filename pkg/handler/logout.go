@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+)
+
+// Logout displays the confirmation to sign out, the session only gets revoked
+// by submitting the form. Otherwise any page could sign out the user with a
+// cross-site link or image.
+func Logout(cfg *config.Config, sessions *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := sessions.Read(r); err != nil {
+			signedOut(cfg, sessions, w, r)
+			return
+		}
+
+		confirm(cfg, sessions, w, r, http.StatusOK, "")
+	}
+}
+
+// Revoke verifies the CSRF token of the confirmation, revokes the session and
+// removes the cookie. Afterwards it redirects to the configured logout URL or
+// displays the signed out page.
+func Revoke(cfg *config.Config, sessions *session.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, err := sessions.Read(r)
+
+		if err != nil {
+			signedOut(cfg, sessions, w, r)
+			return
+		}
+
+		if err := sessions.Verify(r, r.PostFormValue("csrf")); err != nil {
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Str("username", s.User).
+				Msg("rejected logout attempt")
+
+			confirm(cfg, sessions, w, r, http.StatusForbidden, "The logout form has expired, please try again")
+			return
+		}
+
+		if err := sessions.Revoke(s); err != nil {
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Str("username", s.User).
+				Msg("failed to revoke session")
+
+			failure(cfg, w, http.StatusInternalServerError, "Failed to end the session")
+			return
+		}
+
+		hlog.FromRequest(r).Info().
+			Str("username", s.User).
+			Msg("successfully signed out user")
+
+		signedOut(cfg, sessions, w, r)
+	}
+}
+
+func confirm(cfg *config.Config, sessions *session.Manager, w http.ResponseWriter, r *http.Request, status int, msg string) {
+	token, err := sessions.Token(w, r)
+
+	if err != nil {
+		hlog.FromRequest(r).Error().
+			Err(err).
+			Msg("failed to generate csrf token")
+
+		failure(cfg, w, http.StatusInternalServerError, "Failed to prepare the logout form")
+		return
+	}
+
+	render(cfg, w, status, "logout.tmpl", map[string]string{
+		"Error": msg,
+		"CSRF":  token,
+	})
+}
+
+func signedOut(cfg *config.Config, sessions *session.Manager, w http.ResponseWriter, r *http.Request) {
+	sessions.Clear(w, r)
+
+	if cfg.Session.Logout != "" {
+		status := http.StatusFound
+
+		if r.Method == http.MethodPost {
+			status = http.StatusSeeOther
+		}
+
+		http.Redirect(
+			w,
+			r,
+			cfg.Session.Logout,
+			status,
+		)
+
+		return
+	}
+
+	render(cfg, w, http.StatusOK, "logout.tmpl", map[string]string{
+		"Error": "",
+		"CSRF":  "",
+	})
+}
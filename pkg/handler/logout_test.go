@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+)
+
+func TestLogout(t *testing.T) {
+	cfg := config.New()
+	cfg.Server.Root = "/ldap-proxy"
+	cfg.Session.Name = "ldap_proxy"
+	cfg.Session.Secrets = []string{"secret"}
+	cfg.Session.Expire = time.Hour
+
+	sessions, err := session.New(cfg, session.NewMemoryStore(0), nil)
+
+	if err != nil {
+		t.Fatalf("failed to create sessions: %s", err)
+	}
+
+	request := func(method string, body url.Values, jar []*http.Cookie) *http.Request {
+		r := httptest.NewRequest(method, "/ldap-proxy/logout", strings.NewReader(body.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		for _, c := range jar {
+			r.AddCookie(c)
+		}
+
+		return r
+	}
+
+	active := func(jar []*http.Cookie) bool {
+		_, err := sessions.Read(request("GET", nil, jar))
+		return err == nil
+	}
+
+	issue := httptest.NewRecorder()
+
+	if err := sessions.Issue(issue, httptest.NewRequest("POST", "/ldap-proxy/login", nil), &session.Session{User: "alice"}); err != nil {
+		t.Fatalf("failed to issue session: %s", err)
+	}
+
+	jar := issue.Result().Cookies()
+
+	r := request("GET", nil, jar)
+	w := httptest.NewRecorder()
+	Logout(cfg, sessions).ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="csrf"`) {
+		t.Fatalf("expected the confirmation, got %d", w.Code)
+	}
+
+	if !active(jar) {
+		t.Fatalf("expected the session to survive the confirmation")
+	}
+
+	jar = append(jar, w.Result().Cookies()...)
+	token := strings.SplitN(strings.SplitN(w.Body.String(), `name="csrf" type="hidden" value="`, 2)[1], `"`, 2)[0]
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		active bool
+	}{
+		{"missing token", "", http.StatusForbidden, true},
+		{"invalid token", "invalid", http.StatusForbidden, true},
+		{"valid token", token, http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Revoke(cfg, sessions).ServeHTTP(w, request("POST", url.Values{"csrf": {tt.token}}, jar))
+
+			if w.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, w.Code)
+			}
+
+			if active(jar) != tt.active {
+				t.Errorf("expected session active %v", tt.active)
+			}
+		})
+	}
+}
@@ -2,28 +2,106 @@ package handler
 
 import (
 	"net/http"
+	"net/url"
 	"path"
+	"strings"
 
 	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
 )
 
-// Proxy redirects to login or proxies the requests.
-func Proxy(cfg *config.Config, proxy http.Handler) http.HandlerFunc {
+// Proxy redirects to login or proxies the requests to the matching route,
+// clients which are not browsers get a basic auth challenge if the route
+// allows it. The cookies of the proxy are never passed to the upstreams.
+func Proxy(cfg *config.Config, sessions *session.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		r.Header.Add(cfg.Proxy.UserHeader, "myuser")
+		route, ok := upstream.FromContext(r.Context())
 
-		// TODO: check if user is authenticated
+		if !ok {
+			failure(cfg, w, http.StatusNotFound, "There is no application configured for this address")
+			return
+		}
 
-		http.Redirect(
-			w,
-			r,
-			path.Join(
-				cfg.Server.Root,
-				"login",
-			),
-			http.StatusMovedPermanently,
-		)
+		strip(route, r.Header)
 
-		// proxy.ServeHTTP(w, r)
+		s, ok := session.FromContext(r.Context())
+
+		if !ok && route.Basic.Enabled && !browser(r) {
+			challenge(route, w)
+			failure(cfg, w, http.StatusUnauthorized, "Authentication is required")
+			return
+		}
+
+		if !ok {
+			status := http.StatusFound
+
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				status = http.StatusSeeOther
+			}
+
+			http.Redirect(
+				w,
+				r,
+				path.Join(
+					cfg.Server.Root,
+					"login",
+				)+"?"+url.Values{"return_to": {r.URL.RequestURI()}}.Encode(),
+				status,
+			)
+
+			return
+		}
+
+		identity(route, r.Header, s)
+		sessions.Strip(r)
+
+		route.ServeHTTP(w, r)
+	}
+}
+
+// strip removes all identity headers, otherwise clients could spoof them.
+// Variants with underscores are removed as well because many servers treat
+// them like dashes.
+func strip(route *upstream.Route, h http.Header) {
+	names := map[string]bool{
+		normalize(route.UserHeader): true,
+	}
+
+	for _, header := range route.Headers {
+		names[normalize(header.Name)] = true
+	}
+
+	for key := range h {
+		if names[normalize(key)] {
+			delete(h, key)
+		}
+	}
+}
+
+// normalize maps underscores to dashes and lowercases the header name.
+func normalize(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "-", -1))
+}
+
+// identity writes the identity headers based on the session.
+func identity(route *upstream.Route, h http.Header, s *session.Session) {
+	h.Set(route.UserHeader, s.User)
+
+	for _, header := range route.Headers {
+		var values []string
+
+		switch header.Attribute {
+		case "dn":
+			values = []string{s.DN}
+		case "memberOf":
+			values = s.Groups
+		default:
+			values = s.Attributes[header.Attribute]
+		}
+
+		for _, value := range values {
+			h.Add(header.Name, value)
+		}
 	}
 }
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"github.com/webhippie/fail"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/templates"
+)
+
+func render(cfg *config.Config, w http.ResponseWriter, status int, name string, vars map[string]string) {
+	vars["Title"] = cfg.Proxy.Title
+	vars["Root"] = cfg.Server.Root
+
+	buf := bytes.NewBuffer(nil)
+
+	if err := templates.Load(cfg).ExecuteTemplate(buf, name, vars); err != nil {
+		log.Warn().
+			Err(err).
+			Str("template", name).
+			Msg("failed to process template")
+
+		fail.ErrorPlain(w, fail.Cause(err).Unexpected())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	buf.WriteTo(w)
+}
+
+func failure(cfg *config.Config, w http.ResponseWriter, status int, msg string) {
+	render(cfg, w, status, "error.tmpl", map[string]string{
+		"Status": http.StatusText(status),
+		"Error":  msg,
+	})
+}
@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
+)
+
+// Upstream displays an error page if proxying a request failed.
+func Upstream(cfg *config.Config) upstream.ErrorFunc {
+	return func(w http.ResponseWriter, r *http.Request, status int, msg string) {
+		failure(cfg, w, status, msg)
+	}
+}
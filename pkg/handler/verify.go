@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/directory"
+	"github.com/webhippie/ldap-proxy/pkg/lockout"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
+)
+
+// Verify validates the session or the basic auth credentials for the forward
+// authentication of reverse proxies like nginx, Traefik or Caddy. The
+// original request is taken from the forwarded headers, allowed requests get
+// the identity headers of the matching route. Unauthenticated requests are
+// redirected to the login on the original host if the redirect query
+// parameter is set, otherwise they get a 401. The reverse proxy has to
+// forward the root path to us on every host.
+func Verify(cfg *config.Config, dir *directory.Directory, sessions *session.Manager, guard *lockout.Guard, cache *session.Cache, routes *upstream.Table) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := original(r)
+		route := routes.Lookup(req)
+
+		s, err := sessions.Read(r)
+
+		if err != nil && route.Basic.Enabled {
+			if _, _, ok := r.BasicAuth(); ok {
+				if s, ok = basic(cfg, dir, guard, cache, route, w, r); !ok {
+					return
+				}
+
+				err = nil
+			} else if !browser(r) {
+				challenge(route, w)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if err != nil {
+			hlog.FromRequest(r).Debug().
+				Err(err).
+				Str("host", req.Host).
+				Str("uri", req.URL.RequestURI()).
+				Msg("forward auth without valid session")
+
+			if r.URL.Query().Get("redirect") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			login := path.Join(
+				cfg.Server.Root,
+				"login",
+			)
+
+			if req.URL.Scheme != "" {
+				login = req.URL.Scheme + "://" + req.Host + login
+			}
+
+			http.Redirect(
+				w,
+				r,
+				login+"?"+url.Values{"return_to": {req.URL.RequestURI()}}.Encode(),
+				http.StatusFound,
+			)
+
+			return
+		}
+
+		// Sessions of basic auth credentials are not persisted and don't
+		// have an ID, only the sessions of a cookie get renewed.
+		if s.ID != "" {
+			if err := sessions.Renew(w, r, s); err != nil {
+				hlog.FromRequest(r).Warn().
+					Err(err).
+					Str("username", s.User).
+					Msg("failed to renew session")
+			}
+		}
+
+		if !route.Access.Allowed(req, s.Groups) {
+			hlog.FromRequest(r).Info().
+				Str("username", s.User).
+				Str("route", route.Name).
+				Str("host", req.Host).
+				Str("uri", req.URL.RequestURI()).
+				Msg("access denied by policy")
+
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		identity(route, w.Header(), s)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// original rebuilds the request forwarded for authentication based on the
+// X-Original-URL header of nginx or the X-Forwarded-* headers of Traefik and
+// Caddy.
+func original(r *http.Request) *http.Request {
+	req := r.WithContext(r.Context())
+	req.URL = &url.URL{
+		Path: "/",
+	}
+
+	if raw := r.Header.Get("X-Original-URL"); raw != "" {
+		if parsed, err := url.Parse(raw); err == nil {
+			req.URL = parsed
+			req.Host = parsed.Host
+		}
+	} else if raw := r.Header.Get("X-Forwarded-Uri"); raw != "" {
+		if parsed, err := url.ParseRequestURI(raw); err == nil {
+			req.URL = parsed
+		}
+	}
+
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		req.Host = host
+	}
+
+	if req.Host == "" {
+		req.Host = r.Host
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		req.URL.Scheme = proto
+	}
+
+	if req.URL.Scheme != "" {
+		req.URL.Host = req.Host
+	}
+
+	if method := r.Header.Get("X-Forwarded-Method"); method != "" {
+		req.Method = strings.ToUpper(method)
+	} else if method := r.Header.Get("X-Original-Method"); method != "" {
+		req.Method = strings.ToUpper(method)
+	}
+
+	return req
+}
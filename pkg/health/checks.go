@@ -0,0 +1,75 @@
+package health
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/pool"
+	"github.com/webhippie/ldap-proxy/pkg/templates"
+)
+
+// LDAP checks that a bind against the directory succeeds.
+func LDAP(conns *pool.Pool) Checker {
+	return func() error {
+		return conns.Ping()
+	}
+}
+
+// Upstreams checks that at least one of the upstreams is reachable. Without
+// any upstreams the check passes, like for the forward authentication.
+func Upstreams(servers func() []*url.URL, timeout time.Duration) Checker {
+	return func() error {
+		var lastErr error
+
+		for _, server := range servers() {
+			host := server.Host
+
+			if server.Port() == "" {
+				switch server.Scheme {
+				case "https":
+					host = net.JoinHostPort(server.Hostname(), "443")
+				default:
+					host = net.JoinHostPort(server.Hostname(), "80")
+				}
+			}
+
+			conn, err := net.DialTimeout("tcp", host, timeout)
+
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			conn.Close()
+			return nil
+		}
+
+		return lastErr
+	}
+}
+
+// Templates checks that the required templates can be rendered.
+func Templates(cfg *config.Config) Checker {
+	return func() error {
+		return templates.Check(cfg)
+	}
+}
+
+// Certificates checks that the configured certificates can be loaded.
+func Certificates(cfg *config.Config) Checker {
+	return func() error {
+		if cfg.Server.Cert == "" && cfg.Server.Key == "" {
+			return nil
+		}
+
+		_, err := tls.LoadX509KeyPair(
+			cfg.Server.Cert,
+			cfg.Server.Key,
+		)
+
+		return err
+	}
+}
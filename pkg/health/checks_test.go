@@ -0,0 +1,52 @@
+package health
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUpstreams(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	defer l.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	closed.Close()
+
+	reachable := &url.URL{Scheme: "http", Host: l.Addr().String()}
+	unreachable := &url.URL{Scheme: "http", Host: closed.Addr().String()}
+
+	tests := []struct {
+		name    string
+		servers []*url.URL
+		healthy bool
+	}{
+		{"empty", nil, true},
+		{"reachable", []*url.URL{reachable}, true},
+		{"unreachable", []*url.URL{unreachable}, false},
+		{"partially", []*url.URL{unreachable, reachable}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := Upstreams(func() []*url.URL {
+				return tt.servers
+			}, time.Second)
+
+			if err := check(); (err == nil) != tt.healthy {
+				t.Errorf("expected healthy %v, got %v", tt.healthy, err)
+			}
+		})
+	}
+}
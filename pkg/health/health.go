@@ -0,0 +1,124 @@
+package health
+
+import (
+	"sync"
+)
+
+const (
+	// StatusOK defines the status of a passing check.
+	StatusOK = "ok"
+
+	// StatusFailure defines the status of a failing check.
+	StatusFailure = "failure"
+)
+
+// Checker defines a single readiness check.
+type Checker func() error
+
+// Check represents the result of a single check.
+type Check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Result represents the result of all checks.
+type Result struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Health collects the readiness checks of the service.
+type Health struct {
+	mu       sync.RWMutex
+	names    []string
+	checks   map[string]Checker
+	draining bool
+}
+
+// New initializes a new collection of checks.
+func New() *Health {
+	return &Health{
+		names:  []string{},
+		checks: make(map[string]Checker),
+	}
+}
+
+// Register adds a named check, existing checks get replaced.
+func (h *Health) Register(name string, check Checker) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.checks[name]; !ok {
+		h.names = append(h.names, name)
+	}
+
+	h.checks[name] = check
+}
+
+// Drain marks the service as shutting down, all following runs are failing
+// to remove the service from load balancers before it stops.
+func (h *Health) Drain() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.draining = true
+}
+
+// Run executes all checks concurrently and returns the combined result.
+func (h *Health) Run() (*Result, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.draining {
+		return &Result{
+			Status: StatusFailure,
+			Checks: map[string]Check{
+				"shutdown": {
+					Status: StatusFailure,
+					Error:  "service is draining",
+				},
+			},
+		}, false
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	result := &Result{
+		Status: StatusOK,
+		Checks: make(map[string]Check, len(h.names)),
+	}
+
+	for _, name := range h.names {
+		wg.Add(1)
+
+		go func(name string, check Checker) {
+			defer wg.Done()
+
+			err := check()
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				result.Status = StatusFailure
+				result.Checks[name] = Check{
+					Status: StatusFailure,
+					Error:  err.Error(),
+				}
+
+				return
+			}
+
+			result.Checks[name] = Check{
+				Status: StatusOK,
+			}
+		}(name, h.checks[name])
+	}
+
+	wg.Wait()
+
+	return result, result.Status == StatusOK
+}
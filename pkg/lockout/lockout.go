@@ -0,0 +1,366 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/metrics"
+)
+
+const (
+	// KindIP defines the entries tracking the failures of a client address.
+	KindIP = "ip"
+
+	// KindUser defines the entries tracking the failures of a username.
+	KindUser = "user"
+)
+
+var (
+	// ErrThrottled gets returned if the backoff of a previous failure is not
+	// over yet.
+	ErrThrottled = errors.New("too many failed attempts")
+
+	// ErrLocked gets returned if the client or the username is locked.
+	ErrLocked = errors.New("temporarily locked")
+)
+
+// Status represents the failures of a client address or username.
+type Status struct {
+	Kind     string     `json:"kind"`
+	Key      string     `json:"key"`
+	Failures int        `json:"failures"`
+	Last     time.Time  `json:"last"`
+	Locked   *time.Time `json:"locked,omitempty"`
+}
+
+type key struct {
+	kind string
+	name string
+}
+
+type entry struct {
+	failures int
+	last     time.Time
+	locked   time.Time
+}
+
+// Guard tracks failed logins by client address and username. Failures are
+// forgotten after the lockout duration without further failures.
+type Guard struct {
+	cfg *config.Config
+
+	mu      sync.Mutex
+	entries map[key]*entry
+}
+
+// New initializes a new guard for the login.
+func New(cfg *config.Config) *Guard {
+	return &Guard{
+		cfg:     cfg,
+		entries: make(map[key]*entry),
+	}
+}
+
+// Check returns an error with the remaining duration if the request has to
+// be rejected because of previous failures. Otherwise the attempt gets
+// reserved as failure until it's resolved by Fail, Succeed or Release, this
+// way parallel attempts can't bypass the backoff or the threshold.
+func (g *Guard) Check(r *http.Request, username string) (time.Duration, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	keys := g.keys(r, username)
+
+	var (
+		wait   time.Duration
+		locked time.Duration
+	)
+
+	for _, k := range keys {
+		e, ok := g.entries[k]
+
+		if !ok || g.expired(e, now) {
+			continue
+		}
+
+		if !e.locked.IsZero() {
+			if remaining := e.locked.Sub(now); remaining > locked {
+				locked = remaining
+			}
+
+			continue
+		}
+
+		remaining := g.backoff(e.failures) - now.Sub(e.last)
+
+		if threshold := g.threshold(k.kind); threshold > 0 && e.failures >= threshold && remaining < time.Second {
+			remaining = time.Second
+		}
+
+		if remaining > wait {
+			wait = remaining
+		}
+	}
+
+	if locked > 0 {
+		return locked, ErrLocked
+	}
+
+	if wait > 0 {
+		return wait, ErrThrottled
+	}
+
+	for _, k := range keys {
+		e := g.entry(k, now)
+
+		e.failures++
+		e.last = now
+	}
+
+	return 0, nil
+}
+
+// Fail records the attempt reserved by Check as failure, the client address
+// or the username gets locked after reaching the threshold.
+func (g *Guard) Fail(r *http.Request, username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+
+	for _, k := range g.keys(r, username) {
+		e, ok := g.entries[k]
+
+		if !ok || g.expired(e, now) {
+			e = g.entry(k, now)
+			e.failures++
+		}
+
+		e.last = now
+
+		if threshold := g.threshold(k.kind); threshold > 0 && e.failures >= threshold && e.locked.IsZero() {
+			e.locked = now.Add(g.cfg.Lockout.Duration)
+
+			metrics.LoginLockouts.WithLabelValues(k.kind).Inc()
+
+			hlog.FromRequest(r).Warn().
+				Str("kind", k.kind).
+				Str("key", k.name).
+				Int("failures", e.failures).
+				Time("until", e.locked).
+				Msg("locked after repeated login failures")
+		}
+	}
+}
+
+// Succeed resets the failures of the username after a successful login, the
+// failures of the client address are kept.
+func (g *Guard) Succeed(r *http.Request, username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.release(key{kind: KindIP, name: address(r)})
+	delete(g.entries, key{kind: KindUser, name: normalize(username)})
+}
+
+// Release gives back the attempt reserved by Check without counting it as
+// failure, like if the directory is not available.
+func (g *Guard) Release(r *http.Request, username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, k := range g.keys(r, username) {
+		g.release(k)
+	}
+}
+
+// Clear removes the failures and the lockout of a client address or username.
+func (g *Guard) Clear(kind, name string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	k := key{kind: kind, name: name}
+
+	if kind == KindUser {
+		k.name = normalize(name)
+	}
+
+	if _, ok := g.entries[k]; !ok {
+		return false
+	}
+
+	delete(g.entries, k)
+	return true
+}
+
+// Status returns the tracked client addresses and usernames.
+func (g *Guard) Status() []Status {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	result := make([]Status, 0, len(g.entries))
+
+	for k, e := range g.entries {
+		if g.expired(e, now) {
+			continue
+		}
+
+		status := Status{
+			Kind:     k.kind,
+			Key:      k.name,
+			Failures: e.failures,
+			Last:     e.last,
+		}
+
+		if !e.locked.IsZero() {
+			locked := e.locked
+			status.Locked = &locked
+		}
+
+		result = append(result, status)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Kind != result[j].Kind {
+			return result[i].Kind < result[j].Kind
+		}
+
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
+
+// Run periodically removes the expired entries until the context gets
+// canceled.
+func (g *Guard) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			g.prune()
+		}
+	}
+}
+
+func (g *Guard) prune() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+
+	for k, e := range g.entries {
+		if g.expired(e, now) {
+			delete(g.entries, k)
+		}
+	}
+}
+
+// entry returns the entry of the key, missing or expired entries get
+// replaced by an empty one.
+func (g *Guard) entry(k key, now time.Time) *entry {
+	e, ok := g.entries[k]
+
+	if !ok || g.expired(e, now) {
+		e = &entry{}
+		g.entries[k] = e
+	}
+
+	return e
+}
+
+// release removes a reserved attempt, entries without failures get dropped.
+func (g *Guard) release(k key) {
+	e, ok := g.entries[k]
+
+	if !ok || !e.locked.IsZero() {
+		return
+	}
+
+	if e.failures--; e.failures <= 0 {
+		delete(g.entries, k)
+	}
+}
+
+// expired checks if the lockout is over or if the last failure is older than
+// the lockout duration.
+func (g *Guard) expired(e *entry, now time.Time) bool {
+	if !e.locked.IsZero() {
+		return !now.Before(e.locked)
+	}
+
+	return now.Sub(e.last) > g.cfg.Lockout.Duration
+}
+
+// backoff doubles the delay with every failure up to the maximum backoff.
+func (g *Guard) backoff(failures int) time.Duration {
+	base := g.cfg.Lockout.Backoff
+
+	if base <= 0 || failures <= 0 {
+		return 0
+	}
+
+	max := g.cfg.Lockout.MaxBackoff
+
+	for i := 1; i < failures; i++ {
+		if base >= max/2 {
+			return max
+		}
+
+		base *= 2
+	}
+
+	if base > max {
+		return max
+	}
+
+	return base
+}
+
+func (g *Guard) threshold(kind string) int {
+	if kind == KindIP {
+		return g.cfg.Lockout.IPThreshold
+	}
+
+	return g.cfg.Lockout.Threshold
+}
+
+func (g *Guard) keys(r *http.Request, username string) []key {
+	result := []key{
+		{kind: KindIP, name: address(r)},
+	}
+
+	if name := normalize(username); name != "" {
+		result = append(result, key{kind: KindUser, name: name})
+	}
+
+	return result
+}
+
+// address strips the port, the remote address only contains the IP if it got
+// replaced by the real IP middleware.
+func address(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+func normalize(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
@@ -0,0 +1,203 @@
+package lockout
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+func guard(threshold, ipThreshold int, backoff time.Duration) *Guard {
+	cfg := config.New()
+	cfg.Lockout.Threshold = threshold
+	cfg.Lockout.IPThreshold = ipThreshold
+	cfg.Lockout.Duration = time.Hour
+	cfg.Lockout.Backoff = backoff
+	cfg.Lockout.MaxBackoff = 8 * backoff
+
+	return New(cfg)
+}
+
+func TestBackoff(t *testing.T) {
+	g := guard(0, 0, time.Second)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{10, 8 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := g.backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	g := guard(0, 0, time.Minute)
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	if _, err := g.Check(r, "alice"); err != nil {
+		t.Fatalf("expected first attempt to pass, got %s", err)
+	}
+
+	g.Fail(r, "alice")
+
+	wait, err := g.Check(r, "alice")
+
+	if err != ErrThrottled {
+		t.Fatalf("expected %s, got %v", ErrThrottled, err)
+	}
+
+	if wait <= 0 || wait > time.Minute {
+		t.Errorf("expected wait within the backoff, got %s", wait)
+	}
+
+	other := httptest.NewRequest("POST", "/login", nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+
+	if _, err := g.Check(other, "ALICE "); err != ErrThrottled {
+		t.Errorf("expected username to be throttled from another address, got %v", err)
+	}
+
+	if _, err := g.Check(r, "bob"); err != ErrThrottled {
+		t.Errorf("expected address to be throttled for another username, got %v", err)
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	tests := []struct {
+		name        string
+		threshold   int
+		ipThreshold int
+		failures    int
+		want        error
+	}{
+		{"below", 3, 0, 2, nil},
+		{"user", 3, 0, 3, ErrLocked},
+		{"ip", 0, 2, 2, ErrLocked},
+		{"disabled", 0, 0, 5, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := guard(tt.threshold, tt.ipThreshold, 0)
+			r := httptest.NewRequest("POST", "/login", nil)
+
+			for i := 0; i < tt.failures; i++ {
+				if _, err := g.Check(r, "alice"); err != nil {
+					t.Fatalf("expected attempt %d to pass, got %s", i+1, err)
+				}
+
+				g.Fail(r, "alice")
+			}
+
+			if _, err := g.Check(r, "alice"); err != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestReserve(t *testing.T) {
+	g := guard(3, 0, 0)
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if _, err := g.Check(r, "alice"); err == nil {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if allowed != 3 {
+		t.Errorf("expected 3 parallel attempts to pass, got %d", allowed)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	g := guard(2, 0, time.Minute)
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	for i := 0; i < 5; i++ {
+		if _, err := g.Check(r, "alice"); err != nil {
+			t.Fatalf("expected released attempt %d to pass, got %s", i+1, err)
+		}
+
+		g.Release(r, "alice")
+	}
+
+	if status := g.Status(); len(status) != 0 {
+		t.Errorf("expected no entries after releasing, got %v", status)
+	}
+}
+
+func TestSucceed(t *testing.T) {
+	g := guard(0, 0, time.Minute)
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	g.Check(r, "alice")
+	g.Fail(r, "alice")
+
+	other := httptest.NewRequest("POST", "/login", nil)
+	other.RemoteAddr = "192.0.2.2:1234"
+
+	g.Check(other, "bob")
+	g.Succeed(other, "bob")
+
+	if _, err := g.Check(other, "bob"); err != nil {
+		t.Errorf("expected success to reset the backoff, got %s", err)
+	}
+
+	if _, err := g.Check(r, "alice"); err != ErrThrottled {
+		t.Errorf("expected failures of others to be kept, got %v", err)
+	}
+}
+
+func TestClear(t *testing.T) {
+	g := guard(1, 0, 0)
+	r := httptest.NewRequest("POST", "/login", nil)
+
+	g.Check(r, "Alice")
+	g.Fail(r, "Alice")
+
+	if _, err := g.Check(r, "alice"); err != ErrLocked {
+		t.Fatalf("expected %s, got %v", ErrLocked, err)
+	}
+
+	if !g.Clear(KindUser, "ALICE") {
+		t.Errorf("expected the username to be cleared")
+	}
+
+	if g.Clear(KindUser, "alice") {
+		t.Errorf("expected the username to be cleared only once")
+	}
+
+	if _, err := g.Check(r, "alice"); err != nil {
+		t.Errorf("expected cleared username to pass, got %s", err)
+	}
+}
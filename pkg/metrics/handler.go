@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type attemptsKey struct{}
+
+// Attempts attaches an attempt counter to the request, it has to wrap the
+// buffer which retries the requests.
+func Attempts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var attempts int32
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), attemptsKey{}, &attempts)))
+	})
+}
+
+// Retries counts every attempt after the first one as retry, it has to be
+// wrapped by the buffer which retries the requests.
+func Retries(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts, ok := r.Context().Value(attemptsKey{}).(*int32); ok {
+			if atomic.AddInt32(attempts, 1) > 1 {
+				ProxyRetries.Inc()
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Upstream records count and latency of requests per upstream, it has to
+// wrap the forwarder after the balancer have chosen the upstream.
+func Upstream(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		upstream := r.URL.Host
+
+		ww := &statusWriter{
+			ResponseWriter: w,
+		}
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.status
+
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		ProxyRequests.WithLabelValues(upstream, strconv.Itoa(status)).Inc()
+		ProxyDuration.WithLabelValues(upstream).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusWriter records the status code of the response. Other than the
+// wrappers of chi it always keeps the optional interfaces, the forwarder has
+// to hijack upgraded connections and to flush streams.
+type statusWriter struct {
+	http.ResponseWriter
+
+	status int
+}
+
+// WriteHeader implements the http.ResponseWriter interface.
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements the http.ResponseWriter interface.
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements the http.Flusher interface.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify implements the http.CloseNotifier interface.
+func (w *statusWriter) CloseNotify() <-chan bool {
+	if n, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return n.CloseNotify()
+	}
+
+	return make(chan bool)
+}
+
+// Hijack implements the http.Hijacker interface, hijacked connections are
+// recorded as switched protocols.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+
+	return h.Hijack()
+}
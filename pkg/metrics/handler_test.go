@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// plainWriter supports flushing and hijacking but not io.ReaderFrom, the
+// wrappers of chi dropped the hijacker for such writers.
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+func (w *plainWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *plainWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *plainWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+func TestUpstreamHijack(t *testing.T) {
+	handler := Upstream(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("expected response writer to implement http.Flusher")
+		}
+
+		h, ok := w.(http.Hijacker)
+
+		if !ok {
+			t.Errorf("expected response writer to implement http.Hijacker")
+			http.Error(w, "no hijacker", http.StatusInternalServerError)
+			return
+		}
+
+		conn, rw, err := h.Hijack()
+
+		if err != nil {
+			t.Errorf("failed to hijack: %s", err)
+			return
+		}
+
+		defer conn.Close()
+
+		fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+	}))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(&plainWriter{w}, r)
+	}))
+
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+
+	if err != nil {
+		t.Fatalf("failed to read response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("expected status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+}
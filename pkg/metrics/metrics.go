@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "ldap_proxy"
+)
+
+var (
+	// LoginAttempts counts the login attempts by outcome.
+	LoginAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "login_attempts_total",
+			Help:      "How many login attempts have been made by outcome.",
+		},
+		[]string{"outcome"},
+	)
+
+	// LoginLockouts counts the lockouts after repeated login failures by kind.
+	LoginLockouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "lockouts_total",
+			Help:      "How many client addresses and usernames have been locked by kind.",
+		},
+		[]string{"kind"},
+	)
+
+	// BasicAttempts counts the basic authentications by outcome.
+	BasicAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "basic_attempts_total",
+			Help:      "How many basic authentications have been made by outcome.",
+		},
+		[]string{"outcome"},
+	)
+
+	// LDAPDuration observes the latency of LDAP operations.
+	LDAPDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "ldap",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of LDAP operations by operation and result.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"operation", "result"},
+	)
+
+	// SessionsIssued counts the issued sessions.
+	SessionsIssued = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sessions",
+			Name:      "issued_total",
+			Help:      "How many sessions have been issued.",
+		},
+	)
+
+	// SessionsActive tracks the sessions which are not expired yet.
+	SessionsActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "sessions",
+			Name:      "active",
+			Help:      "How many issued sessions are not expired yet.",
+		},
+	)
+
+	// SessionsRevoked counts the sessions revoked by a logout.
+	SessionsRevoked = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "sessions",
+			Name:      "revoked_total",
+			Help:      "How many sessions have been revoked.",
+		},
+	)
+
+	// ProxyRequests counts the proxied requests by upstream and status.
+	ProxyRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "requests_total",
+			Help:      "How many requests have been proxied by upstream and status code.",
+		},
+		[]string{"upstream", "code"},
+	)
+
+	// ProxyDuration observes the latency of proxied requests.
+	ProxyDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of proxied requests by upstream.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"upstream"},
+	)
+
+	// ProxyRetries counts the retries of proxied requests.
+	ProxyRetries = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "retries_total",
+			Help:      "How many proxied requests have been retried.",
+		},
+	)
+
+	// ProxyStreams tracks the open streams by kind.
+	ProxyStreams = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "streams_open",
+			Help:      "How many websockets and streams are currently open by kind.",
+		},
+		[]string{"kind"},
+	)
+
+	// ProxyStreamsTotal counts the opened streams by kind.
+	ProxyStreamsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "proxy",
+			Name:      "streams_total",
+			Help:      "How many websockets and streams have been opened by kind.",
+		},
+		[]string{"kind"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(
+		LoginAttempts,
+		LoginLockouts,
+		BasicAttempts,
+		LDAPDuration,
+		SessionsIssued,
+		SessionsActive,
+		SessionsRevoked,
+		ProxyRequests,
+		ProxyDuration,
+		ProxyRetries,
+		ProxyStreams,
+		ProxyStreamsTotal,
+	)
+}
@@ -0,0 +1,103 @@
+package realip
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP replaces the remote address with the client address from the
+// X-Forwarded-For or X-Real-IP header, but only for requests of the trusted
+// proxies. Other clients could spoof their address otherwise.
+func RealIP(proxies []string) func(http.Handler) http.Handler {
+	trusted := Networks(proxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := client(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Networks parses the trusted proxies, which are defined as addresses or
+// CIDR ranges. Invalid values are skipped.
+func Networks(proxies []string) []*net.IPNet {
+	result := make([]*net.IPNet, 0, len(proxies))
+
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			result = append(result, network)
+			continue
+		}
+
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * net.IPv6len
+
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			result = append(result, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+		}
+	}
+
+	return result
+}
+
+// client walks the forwarded addresses from the right and returns the first
+// one which is not a trusted proxy.
+func client(r *http.Request, trusted []*net.IPNet) string {
+	peer := r.RemoteAddr
+
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+
+	if !contains(trusted, net.ParseIP(peer)) {
+		return ""
+	}
+
+	if forwarded := r.Header["X-Forwarded-For"]; len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[i]))
+
+			if hop == nil {
+				break
+			}
+
+			if i == 0 || !contains(trusted, hop) {
+				return hop.String()
+			}
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+
+	return ""
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
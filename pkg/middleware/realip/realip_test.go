@@ -0,0 +1,55 @@
+package realip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   []string
+		remote    string
+		forwarded string
+		real      string
+		want      string
+	}{
+		{"untrusted", nil, "192.0.2.1:1234", "198.51.100.1", "", "192.0.2.1:1234"},
+		{"untrusted real ip", nil, "192.0.2.1:1234", "", "198.51.100.1", "192.0.2.1:1234"},
+		{"trusted", []string{"192.0.2.1"}, "192.0.2.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted cidr", []string{"192.0.2.0/24"}, "192.0.2.1:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"trusted real ip", []string{"192.0.2.1"}, "192.0.2.1:1234", "", "198.51.100.1", "198.51.100.1"},
+		{"spoofed chain", []string{"192.0.2.1"}, "192.0.2.1:1234", "203.0.113.1, 198.51.100.1", "", "198.51.100.1"},
+		{"trusted chain", []string{"192.0.2.0/24"}, "192.0.2.1:1234", "198.51.100.1, 192.0.2.2", "", "198.51.100.1"},
+		{"invalid hop", []string{"192.0.2.1"}, "192.0.2.1:1234", "garbage", "", "192.0.2.1:1234"},
+		{"ipv6", []string{"2001:db8::1"}, "[2001:db8::1]:1234", "2001:db8::2", "", "2001:db8::2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+
+			handler := RealIP(tt.proxies)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+
+			if tt.real != "" {
+				r.Header.Set("X-Real-IP", tt.real)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("expected remote address %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package timeout
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Timeout cancels the context of requests after the timeout returned for the
+// request, a zero timeout like for websockets and streams disables it.
+func Timeout(timeout func(*http.Request) time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := timeout(r)
+
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package policy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+// Policy evaluates the access rules for authenticated requests.
+type Policy struct {
+	mu    sync.RWMutex
+	rules []*rule
+	allow bool
+}
+
+// New compiles the access rules into a policy.
+func New(access config.Access) (*Policy, error) {
+	p := &Policy{}
+
+	if err := p.Update(access); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Update compiles the access rules and replaces the current rules, the
+// current rules are kept if the new rules are invalid.
+func (p *Policy) Update(access config.Access) error {
+	var allow bool
+
+	switch strings.ToLower(access.Default) {
+	case "", "allow":
+		allow = true
+	case "deny":
+		allow = false
+	default:
+		return fmt.Errorf("invalid default access: %s", access.Default)
+	}
+
+	rules := make([]*rule, 0, len(access.Rules))
+
+	for i, r := range access.Rules {
+		compiled, err := newRule(r)
+
+		if err != nil {
+			return fmt.Errorf("invalid access rule %d: %s", i, err)
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rules = rules
+	p.allow = allow
+
+	return nil
+}
+
+// Allowed checks if the given groups are allowed to access the request. The
+// first rule matching the request decides, if no rule matches the default
+// gets applied.
+func (p *Policy) Allowed(r *http.Request, groups []string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, rule := range p.rules {
+		if rule.matches(r) {
+			return rule.allowed(groups)
+		}
+	}
+
+	return p.allow
+}
+
+type rule struct {
+	host    string
+	path    string
+	regex   *regexp.Regexp
+	methods []string
+	allow   []string
+	deny    []string
+}
+
+func newRule(r config.Rule) (*rule, error) {
+	result := &rule{
+		host:    strings.ToLower(r.Host),
+		path:    r.Path,
+		methods: r.Methods,
+		allow:   r.Allow,
+		deny:    r.Deny,
+	}
+
+	if r.Regex != "" {
+		regex, err := regexp.Compile(r.Regex)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.regex = regex
+	}
+
+	return result, nil
+}
+
+func (r *rule) matches(req *http.Request) bool {
+	if r.host != "" && !MatchHost(r.host, req.Host) {
+		return false
+	}
+
+	clean := Path(req)
+
+	if r.path != "" && !MatchPath(r.path, clean) {
+		return false
+	}
+
+	if r.regex != nil && !r.regex.MatchString(clean) {
+		return false
+	}
+
+	if len(r.methods) > 0 {
+		for _, method := range r.methods {
+			if strings.EqualFold(method, req.Method) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func (r *rule) allowed(groups []string) bool {
+	for _, group := range r.deny {
+		if Member(groups, group) {
+			return false
+		}
+	}
+
+	if len(r.allow) == 0 {
+		return true
+	}
+
+	for _, group := range r.allow {
+		if Member(groups, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Member checks if the group is part of the groups, the group can be defined
+// as full DN or just as the value of the first RDN, like the common name.
+func Member(groups []string, group string) bool {
+	for _, g := range groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+
+		if rdn := strings.SplitN(g, ",", 2)[0]; strings.Contains(rdn, "=") {
+			if strings.EqualFold(strings.SplitN(rdn, "=", 2)[1], group) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Path returns the path of the request used for matching. The path is already
+// decoded, including encoded slashes, and gets cleaned from dot segments and
+// duplicate slashes like most upstreams do it, otherwise paths like //admin
+// or /x/../admin would bypass the rules.
+func Path(r *http.Request) string {
+	result := path.Clean("/" + r.URL.Path)
+
+	if result != "/" && strings.HasSuffix(r.URL.Path, "/") {
+		result = result + "/"
+	}
+
+	return result
+}
+
+// MatchPath checks if the path starts with the prefix on a segment boundary,
+// the prefix /admin matches /admin and /admin/users but not /administrator.
+func MatchPath(prefix, p string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	if prefix == "" {
+		return true
+	}
+
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// MatchHost checks if the host matches the pattern, the pattern can start
+// with a wildcard like *.example.com to match all subdomains.
+func MatchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.ToLower(host)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+
+	return pattern == host
+}
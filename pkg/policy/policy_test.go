@@ -0,0 +1,192 @@
+package policy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+func request(t *testing.T, method, host, target string) *http.Request {
+	u, err := url.ParseRequestURI(target)
+
+	if err != nil {
+		t.Fatalf("failed to parse %q: %s", target, err)
+	}
+
+	return &http.Request{
+		Method: method,
+		Host:   host,
+		URL:    u,
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	p, err := New(config.Access{
+		Default: "allow",
+		Rules: []config.Rule{
+			{
+				Host: "admin.example.com",
+				Deny: []string{"users"},
+			},
+			{
+				Path:  "/admin",
+				Allow: []string{"admins"},
+			},
+			{
+				Regex: `^/api/v[0-9]+/secret`,
+				Allow: []string{"admins"},
+			},
+			{
+				Path:    "/write",
+				Methods: []string{"POST", "DELETE"},
+				Allow:   []string{"editors"},
+			},
+			{
+				Host:  "*.internal.example.com",
+				Allow: []string{"ops"},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("failed to compile policy: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		host   string
+		target string
+		groups []string
+		want   bool
+	}{
+		{"default", "GET", "example.com", "/", []string{"users"}, true},
+		{"host deny", "GET", "admin.example.com", "/", []string{"users"}, false},
+		{"host port", "GET", "admin.example.com:8080", "/", []string{"users"}, false},
+		{"host case", "GET", "Admin.Example.COM", "/", []string{"users"}, false},
+		{"host other", "GET", "other.example.com", "/", []string{"users"}, true},
+		{"wildcard", "GET", "db.internal.example.com", "/", []string{"users"}, false},
+		{"wildcard allow", "GET", "db.internal.example.com", "/", []string{"ops"}, true},
+		{"wildcard apex", "GET", "internal.example.com", "/", []string{"users"}, true},
+		{"path", "GET", "example.com", "/admin", []string{"users"}, false},
+		{"path allow", "GET", "example.com", "/admin", []string{"admins"}, true},
+		{"path dn", "GET", "example.com", "/admin", []string{"cn=admins,ou=groups,dc=example,dc=com"}, true},
+		{"path nested", "GET", "example.com", "/admin/users", []string{"users"}, false},
+		{"path boundary", "GET", "example.com", "/administrator", []string{"users"}, true},
+		{"double slash", "GET", "example.com", "//admin", []string{"users"}, false},
+		{"dot dot", "GET", "example.com", "/x/../admin", []string{"users"}, false},
+		{"dot", "GET", "example.com", "/admin/./", []string{"users"}, false},
+		{"trailing slash", "GET", "example.com", "/admin/", []string{"users"}, false},
+		{"encoded slash", "GET", "example.com", "/x%2F..%2Fadmin", []string{"users"}, false},
+		{"encoded dots", "GET", "example.com", "/x/%2e%2e/admin", []string{"users"}, false},
+		{"regex", "GET", "example.com", "/api/v2/secret", []string{"users"}, false},
+		{"regex traversal", "GET", "example.com", "/api/v2/public/../secret", []string{"users"}, false},
+		{"regex allow", "GET", "example.com", "/api/v2/secret", []string{"admins"}, true},
+		{"regex other", "GET", "example.com", "/api/v2/public", []string{"users"}, true},
+		{"method", "POST", "example.com", "/write", []string{"users"}, false},
+		{"method case", "delete", "example.com", "/write", []string{"users"}, false},
+		{"method allow", "POST", "example.com", "/write", []string{"editors"}, true},
+		{"method other", "GET", "example.com", "/write", []string{"users"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Allowed(request(t, tt.method, tt.host, tt.target), tt.groups); got != tt.want {
+				t.Errorf("Allowed(%s %s%s, %v) = %v, want %v", tt.method, tt.host, tt.target, tt.groups, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultDeny(t *testing.T) {
+	p, err := New(config.Access{
+		Default: "deny",
+		Rules: []config.Rule{
+			{
+				Path: "/public",
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("failed to compile policy: %s", err)
+	}
+
+	if !p.Allowed(request(t, "GET", "example.com", "/public/index.html"), nil) {
+		t.Errorf("expected public path to be allowed")
+	}
+
+	if p.Allowed(request(t, "GET", "example.com", "/public/../private"), nil) {
+		t.Errorf("expected traversal out of the public path to be denied")
+	}
+}
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/", "/"},
+		{"//admin", "/admin"},
+		{"/x/../admin", "/admin"},
+		{"/../admin", "/admin"},
+		{"/admin/./", "/admin/"},
+		{"/admin//users", "/admin/users"},
+		{"/admin%2Fusers", "/admin/users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := Path(request(t, "GET", "example.com", tt.target)); got != tt.want {
+				t.Errorf("Path(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		prefix string
+		path   string
+		want   bool
+	}{
+		{"", "/anything", true},
+		{"/", "/anything", true},
+		{"/admin", "/admin", true},
+		{"/admin", "/admin/", true},
+		{"/admin", "/admin/users", true},
+		{"/admin/", "/admin", true},
+		{"/admin", "/administrator", false},
+		{"/admin", "/", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchPath(tt.prefix, tt.path); got != tt.want {
+			t.Errorf("MatchPath(%q, %q) = %v, want %v", tt.prefix, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com:443", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "badexample.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchHost(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("MatchHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
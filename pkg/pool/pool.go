@@ -0,0 +1,459 @@
+package pool
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/metrics"
+	"gopkg.in/ldap.v2"
+)
+
+// verifyAfter defines how long a connection can be idle before it gets
+// verified prior to reuse.
+const verifyAfter = 10 * time.Second
+
+var (
+	// ErrNoServers gets returned if no LDAP servers are configured.
+	ErrNoServers = errors.New("no ldap servers configured")
+)
+
+// Conn wraps a pooled LDAP connection.
+type Conn struct {
+	*ldap.Conn
+
+	pool   *Pool
+	server *server
+	bound  bool
+	used   time.Time
+}
+
+// Bind authenticates the connection with the given credentials, the
+// connection gets bound to the service account again before it gets reused.
+func (c *Conn) Bind(username, password string) error {
+	c.bound = false
+	return c.bind(username, password)
+}
+
+// Search performs a search request and records the latency.
+func (c *Conn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	start := time.Now()
+	res, err := c.Conn.Search(req)
+
+	observe("search", start, err)
+	return res, err
+}
+
+func (c *Conn) bind(username, password string) error {
+	start := time.Now()
+
+	_, err := c.Conn.SimpleBind(&ldap.SimpleBindRequest{
+		Username:           username,
+		Password:           password,
+		AllowEmptyPassword: username == "",
+	})
+
+	observe("bind", start, err)
+	return err
+}
+
+// Reset binds the connection with the service account again. Without a
+// service account an anonymous bind drops the rights of the last user.
+func (c *Conn) Reset() error {
+	if c.bound {
+		return nil
+	}
+
+	if err := c.bind(c.pool.cfg.LDAP.BindUsername, c.pool.cfg.LDAP.BindPassword); err != nil {
+		log.Error().
+			Err(err).
+			Str("server", c.server.addr).
+			Str("username", c.pool.cfg.LDAP.BindUsername).
+			Msg("failed to bind with service account")
+
+		return err
+	}
+
+	c.bound = true
+	return nil
+}
+
+// alive checks if the connection is still usable, connections which have
+// been idle for a while get verified by a search of the root DSE.
+func (c *Conn) alive() bool {
+	if c.IsClosing() {
+		return false
+	}
+
+	if time.Since(c.used) < verifyAfter {
+		return true
+	}
+
+	_, err := c.Search(rootDSE())
+	return err == nil
+}
+
+// Status represents the state of a single LDAP server.
+type Status struct {
+	Addr    string    `json:"addr"`
+	Healthy bool      `json:"healthy"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+type server struct {
+	addr   string
+	host   string
+	secure bool
+
+	mu      sync.RWMutex
+	healthy bool
+	err     error
+	checked time.Time
+}
+
+func (s *server) update(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.healthy = err == nil
+	s.err = err
+	s.checked = time.Now()
+}
+
+func (s *server) status() Status {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := Status{
+		Addr:    s.addr,
+		Healthy: s.healthy,
+		Checked: s.checked,
+	}
+
+	if s.err != nil {
+		result.Error = s.err.Error()
+	}
+
+	return result
+}
+
+// Pool maintains reusable connections to a list of LDAP servers.
+type Pool struct {
+	cfg     *config.Config
+	tls     *tls.Config
+	servers []*server
+	idle    chan *Conn
+	next    uint32
+}
+
+// New initializes a new connection pool.
+func New(cfg *config.Config) (*Pool, error) {
+	t, err := transport(cfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Pool{
+		cfg:     cfg,
+		tls:     t,
+		servers: make([]*server, 0, len(cfg.LDAP.Servers)),
+		idle:    make(chan *Conn, cfg.LDAP.PoolSize),
+	}
+
+	for _, addr := range cfg.LDAP.Servers {
+		host, secure, err := endpoint(addr)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid ldap server %s: %s", addr, err)
+		}
+
+		p.servers = append(p.servers, &server{
+			addr:    addr,
+			host:    host,
+			secure:  secure,
+			healthy: true,
+		})
+	}
+
+	return p, nil
+}
+
+// Get returns an idle connection or opens a new one, new connections are
+// opened in a round-robin fashion to the healthy servers. Idle connections
+// closed by the server in the meantime get discarded.
+func (p *Pool) Get() (*Conn, error) {
+	for {
+		select {
+		case c := <-p.idle:
+			if !c.alive() {
+				p.Discard(c)
+				continue
+			}
+
+			if err := c.Reset(); err != nil {
+				p.Discard(c)
+				continue
+			}
+
+			return c, nil
+		default:
+			return p.open()
+		}
+	}
+}
+
+// Put returns a connection to the pool, it gets closed if the pool is full
+// or if the connection can't be bound to the service account again.
+func (p *Pool) Put(c *Conn) {
+	if err := c.Reset(); err != nil {
+		p.Discard(c)
+		return
+	}
+
+	c.used = time.Now()
+
+	select {
+	case p.idle <- c:
+	default:
+		c.Close()
+	}
+}
+
+// Discard closes a broken connection without returning it to the pool.
+func (p *Pool) Discard(c *Conn) {
+	c.Close()
+}
+
+// Ping verifies that a bind with the service account succeeds.
+func (p *Pool) Ping() error {
+	c, err := p.Get()
+
+	if err != nil {
+		return err
+	}
+
+	c.bound = false
+
+	if err := c.Reset(); err != nil {
+		p.Discard(c)
+		return err
+	}
+
+	p.Put(c)
+	return nil
+}
+
+// Ready checks if at least one LDAP server is healthy.
+func (p *Pool) Ready() bool {
+	for _, s := range p.servers {
+		if s.status().Healthy {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Status returns the current state of all LDAP servers.
+func (p *Pool) Status() []Status {
+	result := make([]Status, 0, len(p.servers))
+
+	for _, s := range p.servers {
+		result = append(result, s.status())
+	}
+
+	return result
+}
+
+// Run periodically probes all servers and idle connections until the context
+// gets canceled.
+func (p *Pool) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.cfg.LDAP.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.probe()
+
+		select {
+		case <-ctx.Done():
+			p.Close()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close closes all idle connections.
+func (p *Pool) Close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) probe() {
+	for _, s := range p.servers {
+		c, err := p.dial(s)
+
+		if err == nil {
+			c.Close()
+		}
+
+		if healthy := s.status().Healthy; healthy != (err == nil) {
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Str("server", s.addr).
+					Msg("ldap server became unhealthy")
+			} else {
+				log.Info().
+					Str("server", s.addr).
+					Msg("ldap server became healthy")
+			}
+		}
+
+		s.update(err)
+	}
+
+	for i := len(p.idle); i > 0; i-- {
+		select {
+		case c := <-p.idle:
+			if _, err := c.Search(rootDSE()); err != nil {
+				p.Discard(c)
+				continue
+			}
+
+			p.Put(c)
+		default:
+			return
+		}
+	}
+}
+
+func (p *Pool) open() (*Conn, error) {
+	if len(p.servers) == 0 {
+		return nil, ErrNoServers
+	}
+
+	var lastErr error
+
+	start := int(atomic.AddUint32(&p.next, 1))
+
+	for _, healthy := range []bool{true, false} {
+		for i := range p.servers {
+			s := p.servers[(start+i)%len(p.servers)]
+
+			if s.status().Healthy != healthy {
+				continue
+			}
+
+			c, err := p.dial(s)
+
+			if err != nil {
+				log.Warn().
+					Err(err).
+					Str("server", s.addr).
+					Msg("failed to connect to ldap server")
+
+				s.update(err)
+				lastErr = err
+
+				continue
+			}
+
+			return c, nil
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (p *Pool) dial(s *server) (*Conn, error) {
+	raw, err := net.DialTimeout("tcp", s.host, p.cfg.LDAP.Timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.tls.Clone()
+
+	if t.ServerName == "" {
+		t.ServerName, _, _ = net.SplitHostPort(s.host)
+	}
+
+	if s.secure {
+		secure := tls.Client(raw, t)
+		secure.SetDeadline(time.Now().Add(p.cfg.LDAP.Timeout))
+
+		if err := secure.Handshake(); err != nil {
+			raw.Close()
+			return nil, err
+		}
+
+		secure.SetDeadline(time.Time{})
+		raw = secure
+	}
+
+	conn := ldap.NewConn(raw, s.secure)
+	conn.Start()
+	conn.SetTimeout(p.cfg.LDAP.Timeout)
+
+	if !s.secure && p.cfg.LDAP.StartTLS {
+		if err := conn.StartTLS(t); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	c := &Conn{
+		Conn:   conn,
+		pool:   p,
+		server: s,
+		bound:  p.cfg.LDAP.BindUsername == "",
+		used:   time.Now(),
+	}
+
+	if err := c.Reset(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func rootDSE() *ldap.SearchRequest {
+	return ldap.NewSearchRequest(
+		"",
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"1.1"},
+		nil,
+	)
+}
+
+func observe(operation string, start time.Time, err error) {
+	result := "success"
+
+	if err != nil {
+		result = "failure"
+	}
+
+	metrics.LDAPDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
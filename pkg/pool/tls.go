@@ -0,0 +1,91 @@
+package pool
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+// endpoint parses the server address, it accepts ldap:// and ldaps:// URLs
+// and plain host:port pairs.
+func endpoint(addr string) (string, bool, error) {
+	if !strings.Contains(addr, "://") {
+		return addr, false, nil
+	}
+
+	parsed, err := url.Parse(addr)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	switch parsed.Scheme {
+	case "ldap":
+		if parsed.Port() == "" {
+			return net.JoinHostPort(parsed.Hostname(), "389"), false, nil
+		}
+
+		return parsed.Host, false, nil
+	case "ldaps":
+		if parsed.Port() == "" {
+			return net.JoinHostPort(parsed.Hostname(), "636"), true, nil
+		}
+
+		return parsed.Host, true, nil
+	default:
+		return "", false, fmt.Errorf("unsupported scheme: %s", parsed.Scheme)
+	}
+}
+
+// transport builds the TLS configuration for the directory connections.
+func transport(cfg *config.Config) (*tls.Config, error) {
+	if cfg.LDAP.Insecure {
+		log.Warn().
+			Msg("verification of ldap server certificates is disabled")
+	}
+
+	result := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.LDAP.ServerName,
+		InsecureSkipVerify: cfg.LDAP.Insecure,
+	}
+
+	if cfg.LDAP.CA != "" {
+		content, err := ioutil.ReadFile(cfg.LDAP.CA)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, errors.New("failed to parse ca certificates")
+		}
+
+		result.RootCAs = pool
+	}
+
+	if cfg.LDAP.Cert != "" || cfg.LDAP.Key != "" {
+		cert, err := tls.LoadX509KeyPair(
+			cfg.LDAP.Cert,
+			cfg.LDAP.Key,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Certificates = []tls.Certificate{cert}
+	}
+
+	return result, nil
+}
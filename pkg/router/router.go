@@ -1,6 +1,7 @@
 package router
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/url"
@@ -14,12 +15,20 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/webhippie/fail"
 	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/directory"
 	"github.com/webhippie/ldap-proxy/pkg/handler"
+	"github.com/webhippie/ldap-proxy/pkg/health"
+	"github.com/webhippie/ldap-proxy/pkg/lockout"
 	"github.com/webhippie/ldap-proxy/pkg/middleware/header"
+	"github.com/webhippie/ldap-proxy/pkg/middleware/realip"
+	"github.com/webhippie/ldap-proxy/pkg/middleware/timeout"
+	"github.com/webhippie/ldap-proxy/pkg/pool"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+	"github.com/webhippie/ldap-proxy/pkg/upstream"
 )
 
 // Load initializes the routing of the application.
-func Load(cfg *config.Config, proxy http.Handler) http.Handler {
+func Load(cfg *config.Config, dir *directory.Directory, sessions *session.Manager, cache *session.Cache, routes *upstream.Table, guard *lockout.Guard) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(hlog.NewHandler(log.Logger))
@@ -38,19 +47,32 @@ func Load(cfg *config.Config, proxy http.Handler) http.Handler {
 			Msg("")
 	}))
 
-	mux.Use(middleware.Timeout(60 * time.Second))
-	mux.Use(middleware.RealIP)
+	mux.Use(timeout.Timeout(routes.Timeout))
+	mux.Use(realip.RealIP(cfg.Server.Proxies))
 
 	mux.Use(header.Version)
 	mux.Use(header.Cache)
 	mux.Use(header.Secure)
 	mux.Use(header.Options)
 
-	mux.NotFound(handler.Proxy(cfg, proxy))
+	mux.NotFound(
+		chi.Chain(
+			sessions.Handler,
+			handler.Basic(cfg, dir, guard, cache, routes),
+			routes.Handler(handler.Forbidden(cfg)),
+		).HandlerFunc(
+			handler.Proxy(cfg, sessions),
+		).ServeHTTP,
+	)
 
 	mux.Route(cfg.Server.Root, func(root chi.Router) {
-		root.Get("/login", handler.Login(cfg))
-		root.Post("/login", handler.Auth(cfg))
+		root.Get("/login", handler.Login(cfg, sessions, routes))
+		root.Post("/login", handler.Auth(cfg, dir, sessions, guard, routes))
+
+		root.Get("/logout", handler.Logout(cfg, sessions))
+		root.Post("/logout", handler.Revoke(cfg, sessions))
+
+		root.HandleFunc("/auth/verify", handler.Verify(cfg, dir, sessions, guard, cache, routes))
 
 		root.Handle("/assets/*", handler.Static(cfg))
 	})
@@ -58,8 +80,8 @@ func Load(cfg *config.Config, proxy http.Handler) http.Handler {
 	return mux
 }
 
-// Status initializes the routing of metrics and healtchecks.
-func Status(cfg *config.Config) http.Handler {
+// Status initializes the routing of metrics, healthchecks and the admin API.
+func Status(cfg *config.Config, conns *pool.Pool, dir *directory.Directory, sessions *session.Manager, routes *upstream.Table, guard *lockout.Guard, checks *health.Health) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(hlog.NewHandler(log.Logger))
@@ -69,7 +91,7 @@ func Status(cfg *config.Config) http.Handler {
 	mux.Use(hlog.RequestIDHandler("request_id", "Request-Id"))
 
 	mux.Use(middleware.Timeout(60 * time.Second))
-	mux.Use(middleware.RealIP)
+	mux.Use(realip.RealIP(cfg.Server.Proxies))
 
 	mux.Use(header.Version)
 	mux.Use(header.Cache)
@@ -87,16 +109,159 @@ func Status(cfg *config.Config) http.Handler {
 		})
 
 		root.Get("/readyz", func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "text/plain")
+			result, ok := checks.Run()
+
+			w.Header().Set("Content-Type", "application/json")
+
+			if !ok {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			} else {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			json.NewEncoder(w).Encode(result)
+		})
+
+		root.Get("/ldapz", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 
-			io.WriteString(w, http.StatusText(http.StatusOK))
+			json.NewEncoder(w).Encode(conns.Status())
+		})
+
+		root.Get("/upstreamz", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+
+			json.NewEncoder(w).Encode(routes.Status())
+		})
+
+		if cfg.Admin.Token == "" && cfg.Admin.Group == "" {
+			return
+		}
+
+		root.Route("/admin", func(admin chi.Router) {
+			admin.Use(handler.Admin(cfg, dir, sessions, guard))
+
+			admin.Get("/sessions", func(w http.ResponseWriter, r *http.Request) {
+				records, err := sessions.List()
+
+				if err != nil {
+					hlog.FromRequest(r).Error().
+						Err(err).
+						Msg("failed to list sessions")
+
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				result := make([]*session.Session, 0, len(records))
+
+				for _, s := range records {
+					if user := r.URL.Query().Get("user"); user == "" || strings.EqualFold(s.User, user) {
+						result = append(result, s)
+					}
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+
+				json.NewEncoder(w).Encode(result)
+			})
+
+			admin.Delete("/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+				id := chi.URLParam(r, "id")
+
+				switch err := sessions.RevokeID(id); err {
+				case nil:
+				case session.ErrRevokedSession:
+					w.WriteHeader(http.StatusNotFound)
+					return
+				default:
+					hlog.FromRequest(r).Error().
+						Err(err).
+						Str("session", id).
+						Msg("failed to revoke session")
+
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				hlog.FromRequest(r).Info().
+					Str("admin", adminName(r)).
+					Str("session", id).
+					Msg("revoked session")
+
+				w.WriteHeader(http.StatusNoContent)
+			})
+
+			admin.Delete("/users/{user}/sessions", func(w http.ResponseWriter, r *http.Request) {
+				user := chi.URLParam(r, "user")
+				count, err := sessions.RevokeUser(user)
+
+				if err != nil {
+					hlog.FromRequest(r).Error().
+						Err(err).
+						Str("username", user).
+						Msg("failed to revoke sessions")
+
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+
+				hlog.FromRequest(r).Info().
+					Str("admin", adminName(r)).
+					Str("username", user).
+					Int("count", count).
+					Msg("revoked sessions of user")
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+
+				json.NewEncoder(w).Encode(map[string]int{
+					"revoked": count,
+				})
+			})
+
+			admin.Get("/lockouts", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+
+				json.NewEncoder(w).Encode(guard.Status())
+			})
+
+			admin.Delete("/lockouts/{kind}/{key}", func(w http.ResponseWriter, r *http.Request) {
+				kind := chi.URLParam(r, "kind")
+				key := chi.URLParam(r, "key")
+
+				if !guard.Clear(kind, key) {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+
+				hlog.FromRequest(r).Info().
+					Str("admin", adminName(r)).
+					Str("kind", kind).
+					Str("key", key).
+					Msg("cleared login lockout")
+
+				w.WriteHeader(http.StatusNoContent)
+			})
 		})
 	})
 
 	return mux
 }
 
+// adminName returns the name of the authenticated admin for the audit log.
+func adminName(r *http.Request) string {
+	if s, ok := session.FromContext(r.Context()); ok {
+		return s.User
+	}
+
+	return ""
+}
+
 // Redirect handles HTTP to HTTPS redirecting.
 func Redirect(cfg *config.Config) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,170 @@
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// cacheCapacity limits the number of cached credentials, the entries which
+// expire first get evicted if the cache is full.
+const cacheCapacity = 10000
+
+// Cache keeps the sessions of successful basic authentications, the entries
+// are keyed by a keyed hash of the credentials and never store the password.
+type Cache struct {
+	secret []byte
+
+	mu      sync.Mutex
+	entries map[string]cached
+}
+
+type cached struct {
+	username string
+	session  *Session
+	expires  time.Time
+}
+
+// NewCache initializes a new credential cache with a random secret.
+func NewCache() *Cache {
+	return &Cache{
+		secret:  securecookie.GenerateRandomKey(32),
+		entries: make(map[string]cached),
+	}
+}
+
+// Get returns a copy of the cached session for the credentials if it's not
+// expired.
+func (c *Cache) Get(username, password string) (*Session, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[c.key(username, password)]
+
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	return clone(entry.session), true
+}
+
+// Set caches a copy of the session for the credentials during the TTL, this
+// way requests can't modify the cached session.
+func (c *Cache) Set(username, password string, s *Session, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.key(username, password)
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= cacheCapacity {
+		c.evict()
+	}
+
+	c.entries[key] = cached{
+		username: username,
+		session:  clone(s),
+		expires:  time.Now().Add(ttl),
+	}
+}
+
+// Delete removes all cached credentials of the user, like after revoking the
+// sessions of the user.
+func (c *Cache) Delete(username string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if strings.EqualFold(entry.username, username) || strings.EqualFold(entry.session.User, username) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Run periodically removes the expired entries until the context gets
+// canceled.
+func (c *Cache) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.prune()
+		}
+	}
+}
+
+func (c *Cache) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// evict removes the expired entries or the entry which expires first.
+func (c *Cache) evict() {
+	var (
+		oldest  string
+		expires time.Time
+	)
+
+	now := time.Now()
+
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+
+		if oldest == "" || entry.expires.Before(expires) {
+			oldest = key
+			expires = entry.expires
+		}
+	}
+
+	if len(c.entries) >= cacheCapacity {
+		delete(c.entries, oldest)
+	}
+}
+
+func (c *Cache) key(username, password string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// clone copies the session including its groups and attributes.
+func clone(s *Session) *Session {
+	result := *s
+
+	if s.Groups != nil {
+		result.Groups = append([]string{}, s.Groups...)
+	}
+
+	if s.Attributes != nil {
+		result.Attributes = make(map[string][]string, len(s.Attributes))
+
+		for name, values := range s.Attributes {
+			result.Attributes[name] = append([]string{}, values...)
+		}
+	}
+
+	return &result
+}
@@ -0,0 +1,105 @@
+package session
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheCopies(t *testing.T) {
+	c := NewCache()
+
+	s := &Session{
+		User:   "alice",
+		Groups: []string{"users"},
+		Attributes: map[string][]string{
+			"mail": {"alice@example.com"},
+		},
+	}
+
+	c.Set("alice", "secret", s, time.Minute)
+
+	s.Groups[0] = "admins"
+	s.Attributes["mail"][0] = "mallory@example.com"
+
+	cached, ok := c.Get("alice", "secret")
+
+	if !ok {
+		t.Fatalf("expected cached session")
+	}
+
+	if cached.Groups[0] != "users" || cached.Attributes["mail"][0] != "alice@example.com" {
+		t.Errorf("expected cached session to be independent of the stored one, got %+v", cached)
+	}
+
+	cached.Groups[0] = "admins"
+	cached.Attributes["mail"] = nil
+
+	if again, _ := c.Get("alice", "secret"); again.Groups[0] != "users" || len(again.Attributes["mail"]) != 1 {
+		t.Errorf("expected cached session to be independent of returned ones, got %+v", again)
+	}
+}
+
+func TestCacheCredentials(t *testing.T) {
+	c := NewCache()
+	c.Set("alice", "secret", &Session{User: "alice"}, time.Minute)
+	c.Set("bob", "secret", &Session{User: "bob"}, -time.Minute)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     bool
+	}{
+		{"valid", "alice", "secret", true},
+		{"wrong password", "alice", "wrong", false},
+		{"unknown user", "mallory", "secret", false},
+		{"expired", "bob", "secret", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := c.Get(tt.username, tt.password); ok != tt.want {
+				t.Errorf("expected cached %v, got %v", tt.want, ok)
+			}
+		})
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	c := NewCache()
+	c.Set("Alice", "secret", &Session{User: "alice"}, time.Minute)
+	c.Set("alice", "other", &Session{User: "alice"}, time.Minute)
+	c.Set("bob", "secret", &Session{User: "bob"}, time.Minute)
+
+	c.Delete("ALICE")
+
+	if _, ok := c.Get("Alice", "secret"); ok {
+		t.Errorf("expected credentials of the user to be removed")
+	}
+
+	if _, ok := c.Get("alice", "other"); ok {
+		t.Errorf("expected all credentials of the user to be removed")
+	}
+
+	if _, ok := c.Get("bob", "secret"); !ok {
+		t.Errorf("expected credentials of other users to be kept")
+	}
+}
+
+func TestCacheCapacity(t *testing.T) {
+	c := NewCache()
+	c.Set("first", "secret", &Session{User: "first"}, time.Minute)
+
+	for i := 0; i < cacheCapacity; i++ {
+		c.Set(fmt.Sprintf("user%d", i), "secret", &Session{}, time.Hour)
+	}
+
+	if len(c.entries) != cacheCapacity {
+		t.Errorf("expected %d entries, got %d", cacheCapacity, len(c.entries))
+	}
+
+	if _, ok := c.Get("first", "secret"); ok {
+		t.Errorf("expected the entry which expires first to be evicted")
+	}
+}
@@ -0,0 +1,18 @@
+package session
+
+import (
+	"context"
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context with the session attached.
+func NewContext(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the session attached to the context, if any.
+func FromContext(ctx context.Context) (*Session, bool) {
+	s, ok := ctx.Value(contextKey{}).(*Session)
+	return s, ok
+}
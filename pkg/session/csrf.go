@@ -0,0 +1,69 @@
+package session
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// ErrInvalidToken gets returned if the CSRF token can't be verified.
+var ErrInvalidToken = errors.New("csrf token is invalid")
+
+// Token returns a signed CSRF token for the login form. The token is bound to
+// a random nonce stored within a separate cookie.
+func (m *Manager) Token(w http.ResponseWriter, r *http.Request) (string, error) {
+	nonce := ""
+
+	if cookie, err := r.Cookie(m.csrfName()); err == nil && cookie.Value != "" {
+		nonce = cookie.Value
+	} else {
+		nonce = base64.RawURLEncoding.EncodeToString(
+			securecookie.GenerateRandomKey(32),
+		)
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     m.csrfName(),
+			Value:    nonce,
+			Path:     m.cfg.Server.Root,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+			Secure:   r.TLS != nil || strings.HasPrefix(m.cfg.Server.Host, "https://"),
+		})
+	}
+
+	return securecookie.EncodeMulti(
+		m.csrfName(),
+		nonce,
+		m.codecs...,
+	)
+}
+
+// Verify checks that the CSRF token got signed by us and matches the nonce
+// of the cookie.
+func (m *Manager) Verify(r *http.Request, token string) error {
+	cookie, err := r.Cookie(m.csrfName())
+
+	if err != nil || cookie.Value == "" || token == "" {
+		return ErrInvalidToken
+	}
+
+	nonce := ""
+
+	if err := securecookie.DecodeMulti(m.csrfName(), token, &nonce, m.codecs...); err != nil {
+		return ErrInvalidToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(cookie.Value)) != 1 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func (m *Manager) csrfName() string {
+	return m.cfg.Session.Name + "_csrf"
+}
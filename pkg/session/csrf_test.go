@@ -0,0 +1,142 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+func manager(t *testing.T, expire time.Duration, secrets ...string) *Manager {
+	cfg := config.New()
+	cfg.Session.Name = "ldap_proxy"
+	cfg.Session.Secrets = secrets
+	cfg.Session.Expire = expire
+
+	m, err := New(cfg, nil, nil)
+
+	if err != nil {
+		t.Fatalf("failed to create manager: %s", err)
+	}
+
+	return m
+}
+
+// token issues a CSRF token and returns it together with the nonce cookie.
+func token(t *testing.T, m *Manager) (string, *http.Cookie) {
+	w := httptest.NewRecorder()
+	result, err := m.Token(w, httptest.NewRequest("GET", "/login", nil))
+
+	if err != nil {
+		t.Fatalf("failed to issue token: %s", err)
+	}
+
+	cookies := w.Result().Cookies()
+
+	if len(cookies) != 1 {
+		t.Fatalf("expected one nonce cookie, got %d", len(cookies))
+	}
+
+	return result, cookies[0]
+}
+
+// tamper flips a character in the middle of the value.
+func tamper(value string) string {
+	b := []byte(value)
+
+	if i := len(b) / 2; b[i] == 'A' {
+		b[i] = 'B'
+	} else {
+		b[i] = 'A'
+	}
+
+	return string(b)
+}
+
+func TestCSRF(t *testing.T) {
+	m := manager(t, time.Hour, "secret")
+	valid, cookie := token(t, m)
+	other, otherCookie := token(t, m)
+
+	tests := []struct {
+		name   string
+		token  string
+		cookie *http.Cookie
+		want   error
+	}{
+		{"valid", valid, cookie, nil},
+		{"missing token", "", cookie, ErrInvalidToken},
+		{"missing cookie", valid, nil, ErrInvalidToken},
+		{"empty cookie", valid, &http.Cookie{Name: cookie.Name}, ErrInvalidToken},
+		{"other cookie", valid, otherCookie, ErrInvalidToken},
+		{"other token", other, cookie, ErrInvalidToken},
+		{"tampered token", tamper(valid), cookie, ErrInvalidToken},
+		{"tampered cookie", valid, &http.Cookie{Name: cookie.Name, Value: cookie.Value + "x"}, ErrInvalidToken},
+		{"nonce as token", cookie.Value, cookie, ErrInvalidToken},
+		{"foreign secret", func() string { v, _ := token(t, manager(t, time.Hour, "other")); return v }(), cookie, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/login", nil)
+
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+
+			if got := m.Verify(r, tt.token); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCSRFReuseNonce(t *testing.T) {
+	m := manager(t, time.Hour, "secret")
+	_, cookie := token(t, m)
+
+	r := httptest.NewRequest("GET", "/login", nil)
+	r.AddCookie(cookie)
+
+	w := httptest.NewRecorder()
+	result, err := m.Token(w, r)
+
+	if err != nil {
+		t.Fatalf("failed to issue token: %s", err)
+	}
+
+	if len(w.Result().Cookies()) != 0 {
+		t.Errorf("expected the existing nonce to be reused")
+	}
+
+	if err := m.Verify(r, result); err != nil {
+		t.Errorf("expected token to be valid, got %s", err)
+	}
+}
+
+func TestCSRFRotation(t *testing.T) {
+	valid, cookie := token(t, manager(t, time.Hour, "old"))
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.AddCookie(cookie)
+
+	if err := manager(t, time.Hour, "new", "old").Verify(r, valid); err != nil {
+		t.Errorf("expected token of a rotated secret to be valid, got %s", err)
+	}
+}
+
+func TestCSRFExpired(t *testing.T) {
+	m := manager(t, time.Second, "secret")
+	valid, cookie := token(t, m)
+
+	time.Sleep(2100 * time.Millisecond)
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.AddCookie(cookie)
+
+	if err := m.Verify(r, valid); err != ErrInvalidToken {
+		t.Errorf("expected expired token to be rejected, got %v", err)
+	}
+}
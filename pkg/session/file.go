@@ -0,0 +1,151 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+var sessionsBucket = []byte("sessions")
+
+// FileStore keeps the sessions within a bolt database, the file can only be
+// opened by a single instance at the same time.
+type FileStore struct {
+	db *bolt.DB
+}
+
+// NewFileStore opens or creates the database at the given path.
+func NewFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		Timeout: 5 * time.Second,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &FileStore{
+		db: db,
+	}, nil
+}
+
+// Load implements the Store interface.
+func (f *FileStore) Load(id string) (*Session, error) {
+	s := &Session{}
+
+	err := f.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(sessionsBucket).Get([]byte(id))
+
+		if value == nil {
+			return ErrRevokedSession
+		}
+
+		return json.Unmarshal(value, s)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Save implements the Store interface.
+func (f *FileStore) Save(s *Session) error {
+	value, err := json.Marshal(s)
+
+	if err != nil {
+		return err
+	}
+
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(s.ID), value)
+	})
+}
+
+// Delete implements the Store interface.
+func (f *FileStore) Delete(id string) error {
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
+
+// List implements the Store interface.
+func (f *FileStore) List() ([]*Session, error) {
+	now := time.Now()
+	result := []*Session{}
+
+	err := f.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(_, value []byte) error {
+			s := &Session{}
+
+			if err := json.Unmarshal(value, s); err != nil {
+				return err
+			}
+
+			if !now.After(s.Expires) {
+				result = append(result, s)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Prune implements the Store interface.
+func (f *FileStore) Prune() error {
+	now := time.Now()
+
+	return f.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(sessionsBucket)
+		expired := [][]byte{}
+
+		// Deleting with the cursor skips the following key, so the keys are
+		// collected first.
+		if err := bucket.ForEach(func(key, value []byte) error {
+			s := &Session{}
+
+			if err := json.Unmarshal(value, s); err != nil || now.After(s.Expires) {
+				expired = append(expired, append([]byte{}, key...))
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Close implements the Store interface.
+func (f *FileStore) Close() error {
+	return f.db.Close()
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the sessions in memory, the least recently used sessions
+// get evicted if the capacity is exceeded.
+type MemoryStore struct {
+	capacity int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemoryStore initializes a new in-memory store, the capacity is unlimited
+// if it's zero.
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Load implements the Store interface.
+func (m *MemoryStore) Load(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[id]
+
+	if !ok {
+		return nil, ErrRevokedSession
+	}
+
+	m.order.MoveToFront(elem)
+
+	result := *elem.Value.(*Session)
+	return &result, nil
+}
+
+// Save implements the Store interface.
+func (m *MemoryStore) Save(s *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value := *s
+
+	if elem, ok := m.entries[s.ID]; ok {
+		elem.Value = &value
+		m.order.MoveToFront(elem)
+
+		return nil
+	}
+
+	m.entries[s.ID] = m.order.PushFront(&value)
+
+	for m.capacity > 0 && m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*Session).ID)
+	}
+
+	return nil
+}
+
+// Delete implements the Store interface.
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[id]; ok {
+		m.order.Remove(elem)
+		delete(m.entries, id)
+	}
+
+	return nil
+}
+
+// List implements the Store interface.
+func (m *MemoryStore) List() ([]*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	result := make([]*Session, 0, len(m.entries))
+
+	for elem := m.order.Front(); elem != nil; elem = elem.Next() {
+		s := *elem.Value.(*Session)
+
+		if now.After(s.Expires) {
+			continue
+		}
+
+		result = append(result, &s)
+	}
+
+	return result, nil
+}
+
+// Prune implements the Store interface.
+func (m *MemoryStore) Prune() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	for id, elem := range m.entries {
+		if now.After(elem.Value.(*Session).Expires) {
+			m.order.Remove(elem)
+			delete(m.entries, id)
+		}
+	}
+
+	return nil
+}
+
+// Close implements the Store interface.
+func (m *MemoryStore) Close() error {
+	return nil
+}
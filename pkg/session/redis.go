@@ -0,0 +1,120 @@
+package session
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+// RedisStore keeps the sessions within Redis or a compatible server, this
+// way multiple instances share the sessions. Redis expires the keys itself.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to the configured server.
+func NewRedisStore(cfg config.Redis) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &RedisStore{
+		client: client,
+		prefix: cfg.Prefix,
+	}, nil
+}
+
+// Load implements the Store interface.
+func (r *RedisStore) Load(id string) (*Session, error) {
+	value, err := r.client.Get(r.prefix + id).Bytes()
+
+	if err == redis.Nil {
+		return nil, ErrRevokedSession
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{}
+
+	if err := json.Unmarshal(value, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Save implements the Store interface.
+func (r *RedisStore) Save(s *Session) error {
+	ttl := time.Until(s.Expires)
+
+	if ttl <= 0 {
+		return r.Delete(s.ID)
+	}
+
+	value, err := json.Marshal(s)
+
+	if err != nil {
+		return err
+	}
+
+	return r.client.Set(r.prefix+s.ID, value, ttl).Err()
+}
+
+// Delete implements the Store interface.
+func (r *RedisStore) Delete(id string) error {
+	return r.client.Del(r.prefix + id).Err()
+}
+
+// List implements the Store interface.
+func (r *RedisStore) List() ([]*Session, error) {
+	result := []*Session{}
+	iter := r.client.Scan(0, r.prefix+"*", 100).Iterator()
+
+	for iter.Next() {
+		value, err := r.client.Get(iter.Val()).Bytes()
+
+		if err == redis.Nil {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		s := &Session{}
+
+		if err := json.Unmarshal(value, s); err != nil {
+			return nil, err
+		}
+
+		result = append(result, s)
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Prune implements the Store interface, Redis removes expired keys itself.
+func (r *RedisStore) Prune() error {
+	return nil
+}
+
+// Close implements the Store interface.
+func (r *RedisStore) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,447 @@
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/rs/zerolog/hlog"
+	"github.com/rs/zerolog/log"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/metrics"
+)
+
+var (
+	// ErrMissingSession gets returned if the request doesn't contain a session.
+	ErrMissingSession = errors.New("session is missing")
+
+	// ErrInvalidSession gets returned if the session can't be verified.
+	ErrInvalidSession = errors.New("session is invalid")
+
+	// ErrExpiredSession gets returned if the session is already expired.
+	ErrExpiredSession = errors.New("session is expired")
+
+	// ErrRevokedSession gets returned if the session has been revoked or if
+	// it's unknown to the store.
+	ErrRevokedSession = errors.New("session is revoked")
+)
+
+// Session represents a session persisted by the store, the cookie only
+// contains the signed ID.
+type Session struct {
+	ID         string              `json:"id"`
+	User       string              `json:"user"`
+	DN         string              `json:"dn"`
+	Groups     []string            `json:"groups"`
+	Attributes map[string][]string `json:"attributes"`
+	IP         string              `json:"ip"`
+	UserAgent  string              `json:"user_agent"`
+	Created    time.Time           `json:"created"`
+	Seen       time.Time           `json:"seen"`
+	Expires    time.Time           `json:"expires"`
+}
+
+// Manager handles issuing and verifying session cookies.
+type Manager struct {
+	cfg    *config.Config
+	store  Store
+	cache  *Cache
+	codecs []securecookie.Codec
+}
+
+// New initializes a new session manager. The first configured secret is used
+// to sign new cookies, all other secrets are only used for verification to
+// support key rotation. The optional cache of basic authentications gets
+// cleared for users whose sessions are revoked.
+func New(cfg *config.Config, store Store, cache *Cache) (*Manager, error) {
+	if len(cfg.Session.Encryption) > len(cfg.Session.Secrets) {
+		return nil, errors.New("every encryption key requires a matching secret")
+	}
+
+	pairs := make([][]byte, 0, len(cfg.Session.Secrets)*2)
+
+	for i, secret := range cfg.Session.Secrets {
+		var block []byte
+
+		if i < len(cfg.Session.Encryption) && cfg.Session.Encryption[i] != "" {
+			block = []byte(cfg.Session.Encryption[i])
+
+			switch len(block) {
+			case 16, 24, 32:
+			default:
+				return nil, errors.New("encryption keys must have a length of 16, 24 or 32 bytes")
+			}
+		}
+
+		pairs = append(pairs, []byte(secret), block)
+	}
+
+	if len(pairs) == 0 {
+		log.Warn().
+			Msg("no session secret defined, generating a random one")
+
+		pairs = append(pairs, securecookie.GenerateRandomKey(64), nil)
+	}
+
+	codecs := securecookie.CodecsFromPairs(pairs...)
+
+	for _, codec := range codecs {
+		codec.(*securecookie.SecureCookie).
+			SetSerializer(securecookie.JSONEncoder{}).
+			MaxAge(int(cfg.Session.Expire.Seconds()))
+	}
+
+	return &Manager{
+		cfg:    cfg,
+		store:  store,
+		cache:  cache,
+		codecs: codecs,
+	}, nil
+}
+
+// Issue persists the session and writes a signed cookie with its ID.
+func (m *Manager) Issue(w http.ResponseWriter, r *http.Request, s *Session) error {
+	now := time.Now()
+
+	s.ID = base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(16))
+	s.Created = now
+	s.Seen = now
+	s.Expires = now.Add(m.cfg.Session.Expire)
+	s.IP = address(r)
+	s.UserAgent = r.UserAgent()
+
+	if err := m.store.Save(s); err != nil {
+		return err
+	}
+
+	if err := m.write(w, r, s); err != nil {
+		return err
+	}
+
+	metrics.SessionsIssued.Inc()
+	metrics.SessionsActive.Inc()
+
+	return nil
+}
+
+// Read extracts and verifies the session cookie from the request and loads
+// the session from the store.
+func (m *Manager) Read(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.cfg.Session.Name)
+
+	if err != nil {
+		return nil, ErrMissingSession
+	}
+
+	id := ""
+
+	if err := securecookie.DecodeMulti(m.cfg.Session.Name, cookie.Value, &id, m.codecs...); err != nil {
+		return nil, ErrInvalidSession
+	}
+
+	s, err := m.store.Load(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if m.expired(s, time.Now()) {
+		m.store.Delete(s.ID)
+		return nil, ErrExpiredSession
+	}
+
+	return s, nil
+}
+
+// Renew records the activity of the session for the idle timeout and extends
+// the expiry for sliding sessions up to the absolute lifetime. The store is
+// only updated once a tenth of the timeout has passed to limit the writes.
+func (m *Manager) Renew(w http.ResponseWriter, r *http.Request, s *Session) error {
+	now := time.Now()
+	window := m.cfg.Session.Idle
+
+	if m.cfg.Session.Sliding && (window <= 0 || m.cfg.Session.Expire < window) {
+		window = m.cfg.Session.Expire
+	}
+
+	if window <= 0 || now.Sub(s.Seen) < window/10 {
+		return nil
+	}
+
+	s.Seen = now
+
+	if !m.cfg.Session.Sliding {
+		return m.store.Save(s)
+	}
+
+	s.Expires = now.Add(m.cfg.Session.Expire)
+
+	if m.cfg.Session.Lifetime > 0 && s.Expires.After(s.Created.Add(m.cfg.Session.Lifetime)) {
+		s.Expires = s.Created.Add(m.cfg.Session.Lifetime)
+	}
+
+	if err := m.store.Save(s); err != nil {
+		return err
+	}
+
+	return m.write(w, r, s)
+}
+
+// Revoke removes the session from the store, this way a copy of the cookie
+// can't be used anymore.
+func (m *Manager) Revoke(s *Session) error {
+	if err := m.store.Delete(s.ID); err != nil {
+		return err
+	}
+
+	metrics.SessionsRevoked.Inc()
+
+	// Expired sessions are not counted as active anymore since the last prune.
+	if !m.expired(s, time.Now()) {
+		metrics.SessionsActive.Dec()
+	}
+
+	return nil
+}
+
+// List returns all active sessions from the store.
+func (m *Manager) List() ([]*Session, error) {
+	sessions, err := m.store.List()
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := make([]*Session, 0, len(sessions))
+
+	for _, s := range sessions {
+		if !m.expired(s, now) {
+			result = append(result, s)
+		}
+	}
+
+	return result, nil
+}
+
+// RevokeID revokes the session with the given ID, it returns
+// ErrRevokedSession if the session is unknown.
+func (m *Manager) RevokeID(id string) error {
+	s, err := m.store.Load(id)
+
+	if err != nil {
+		return err
+	}
+
+	return m.Revoke(s)
+}
+
+// RevokeUser revokes all sessions of the given user and returns the number of
+// revoked sessions. Cached basic authentications of the user are dropped as
+// well, otherwise the user keeps access until they expire.
+func (m *Manager) RevokeUser(username string) (int, error) {
+	if m.cache != nil {
+		m.cache.Delete(username)
+	}
+
+	sessions, err := m.store.List()
+
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for _, s := range sessions {
+		if !strings.EqualFold(s.User, username) {
+			continue
+		}
+
+		if err := m.Revoke(s); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// Run periodically removes expired sessions from the store until the context
+// gets canceled.
+func (m *Manager) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			m.prune()
+		}
+	}
+}
+
+func (m *Manager) prune() {
+	if err := m.store.Prune(); err != nil {
+		log.Warn().
+			Err(err).
+			Msg("failed to prune sessions")
+
+		return
+	}
+
+	sessions, err := m.store.List()
+
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Msg("failed to list sessions")
+
+		return
+	}
+
+	active := 0
+
+	for _, s := range sessions {
+		if !m.expired(s, time.Now()) {
+			active++
+		}
+	}
+
+	metrics.SessionsActive.Set(float64(active))
+}
+
+// expired checks the expiry and the idle timeout of the session.
+func (m *Manager) expired(s *Session, now time.Time) bool {
+	if now.After(s.Expires) {
+		return true
+	}
+
+	if m.cfg.Session.Idle > 0 && now.After(s.Seen.Add(m.cfg.Session.Idle)) {
+		return true
+	}
+
+	return false
+}
+
+// address strips the port from the remote address of the request.
+func address(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func (m *Manager) write(w http.ResponseWriter, r *http.Request, s *Session) error {
+	value, err := securecookie.EncodeMulti(
+		m.cfg.Session.Name,
+		s.ID,
+		m.codecs...,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, m.cookie(r, value, s.Expires))
+	return nil
+}
+
+// Clear removes the session cookie from the client.
+func (m *Manager) Clear(w http.ResponseWriter, r *http.Request) {
+	c := m.cookie(r, "", time.Unix(0, 0))
+	c.MaxAge = -1
+
+	http.SetCookie(w, c)
+}
+
+// Strip removes the session and the CSRF cookie from the request, this way
+// upstreams are never able to replay them.
+func (m *Manager) Strip(r *http.Request) {
+	values := r.Header["Cookie"]
+
+	if len(values) == 0 {
+		return
+	}
+
+	r.Header.Del("Cookie")
+
+	for _, value := range values {
+		parts := make([]string, 0)
+
+		for _, part := range strings.Split(value, ";") {
+			name := strings.TrimSpace(strings.SplitN(part, "=", 2)[0])
+
+			if name == m.cfg.Session.Name || name == m.csrfName() {
+				continue
+			}
+
+			parts = append(parts, strings.TrimSpace(part))
+		}
+
+		if len(parts) > 0 {
+			r.Header.Add("Cookie", strings.Join(parts, "; "))
+		}
+	}
+}
+
+func (m *Manager) cookie(r *http.Request, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.cfg.Session.Name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil || strings.HasPrefix(m.cfg.Server.Host, "https://"),
+	}
+}
+
+// Handler verifies the session cookie and attaches a valid session to the
+// request context, invalid cookies get removed from the client.
+func (m *Manager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := m.Read(r)
+
+		switch err {
+		case nil:
+		case ErrMissingSession:
+			next.ServeHTTP(w, r)
+			return
+		case ErrInvalidSession, ErrExpiredSession, ErrRevokedSession:
+			hlog.FromRequest(r).Debug().
+				Err(err).
+				Msg("failed to verify session")
+
+			m.Clear(w, r)
+			next.ServeHTTP(w, r)
+			return
+		default:
+			hlog.FromRequest(r).Error().
+				Err(err).
+				Msg("failed to load session")
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := m.Renew(w, r, s); err != nil {
+			hlog.FromRequest(r).Warn().
+				Err(err).
+				Str("username", s.User).
+				Msg("failed to renew session")
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), s)))
+	})
+}
@@ -0,0 +1,288 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/metrics"
+)
+
+// sessions creates a manager backed by a memory store and a cache.
+func sessions(t *testing.T, configure func(*config.Session)) (*Manager, Store, *Cache) {
+	cfg := config.New()
+	cfg.Session.Name = "ldap_proxy"
+	cfg.Session.Secrets = []string{"secret"}
+	cfg.Session.Expire = time.Hour
+	cfg.Session.Idle = 0
+	cfg.Session.Lifetime = 0
+	cfg.Session.Sliding = false
+
+	if configure != nil {
+		configure(&cfg.Session)
+	}
+
+	store := NewMemoryStore(0)
+	cache := NewCache()
+
+	m, err := New(cfg, store, cache)
+
+	if err != nil {
+		t.Fatalf("failed to create manager: %s", err)
+	}
+
+	return m, store, cache
+}
+
+// issue creates a session for the user and returns it with its cookie.
+func issue(t *testing.T, m *Manager, user string) (*Session, *http.Cookie) {
+	w := httptest.NewRecorder()
+	s := &Session{User: user}
+
+	if err := m.Issue(w, httptest.NewRequest("GET", "/login", nil), s); err != nil {
+		t.Fatalf("failed to issue session: %s", err)
+	}
+
+	cookies := w.Result().Cookies()
+
+	if len(cookies) != 1 {
+		t.Fatalf("expected one session cookie, got %d", len(cookies))
+	}
+
+	return s, cookies[0]
+}
+
+// active returns the current value of the active sessions gauge.
+func active(t *testing.T) float64 {
+	result := &dto.Metric{}
+
+	if err := metrics.SessionsActive.Write(result); err != nil {
+		t.Fatalf("failed to read gauge: %s", err)
+	}
+
+	return result.GetGauge().GetValue()
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(*config.Session)
+		update    func(*Session)
+		err       error
+	}{
+		{
+			name: "valid",
+		},
+		{
+			name: "expired",
+			update: func(s *Session) {
+				s.Expires = time.Now().Add(-time.Minute)
+			},
+			err: ErrExpiredSession,
+		},
+		{
+			name: "idle",
+			configure: func(cfg *config.Session) {
+				cfg.Idle = 10 * time.Minute
+			},
+			update: func(s *Session) {
+				s.Seen = time.Now().Add(-15 * time.Minute)
+			},
+			err: ErrExpiredSession,
+		},
+		{
+			name: "recently seen",
+			configure: func(cfg *config.Session) {
+				cfg.Idle = 10 * time.Minute
+			},
+			update: func(s *Session) {
+				s.Seen = time.Now().Add(-5 * time.Minute)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, store, _ := sessions(t, tt.configure)
+			s, cookie := issue(t, m, "alice")
+
+			if tt.update != nil {
+				tt.update(s)
+				store.Save(s)
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.AddCookie(cookie)
+
+			if _, err := m.Read(r); err != tt.err {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+
+			_, err := store.Load(s.ID)
+
+			if tt.err != nil && err != ErrRevokedSession {
+				t.Errorf("expected expired session to be deleted, got %v", err)
+			}
+
+			if tt.err == nil && err != nil {
+				t.Errorf("expected valid session to be kept, got %s", err)
+			}
+		})
+	}
+}
+
+func TestRenew(t *testing.T) {
+	tests := []struct {
+		name     string
+		sliding  bool
+		lifetime time.Duration
+		created  time.Duration
+		seen     time.Duration
+		expires  time.Duration
+		cookie   bool
+	}{
+		{
+			name:    "throttled",
+			sliding: true,
+			created: -time.Minute,
+			seen:    -time.Minute,
+			expires: 59 * time.Minute,
+		},
+		{
+			name:    "fixed",
+			created: -30 * time.Minute,
+			seen:    -30 * time.Minute,
+			expires: 30 * time.Minute,
+		},
+		{
+			name:    "sliding",
+			sliding: true,
+			created: -30 * time.Minute,
+			seen:    -30 * time.Minute,
+			expires: time.Hour,
+			cookie:  true,
+		},
+		{
+			name:     "lifetime",
+			sliding:  true,
+			lifetime: 2 * time.Hour,
+			created:  -90 * time.Minute,
+			seen:     -30 * time.Minute,
+			expires:  30 * time.Minute,
+			cookie:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, store, _ := sessions(t, func(cfg *config.Session) {
+				cfg.Idle = time.Hour
+				cfg.Sliding = tt.sliding
+				cfg.Lifetime = tt.lifetime
+			})
+
+			now := time.Now()
+
+			s := &Session{
+				ID:      "renew",
+				User:    "alice",
+				Created: now.Add(tt.created),
+				Seen:    now.Add(tt.seen),
+				Expires: now.Add(tt.created + time.Hour),
+			}
+
+			store.Save(s)
+			seen := s.Seen
+
+			w := httptest.NewRecorder()
+
+			if err := m.Renew(w, httptest.NewRequest("GET", "/", nil), s); err != nil {
+				t.Fatalf("failed to renew session: %s", err)
+			}
+
+			loaded, err := store.Load(s.ID)
+
+			if err != nil {
+				t.Fatalf("failed to load session: %s", err)
+			}
+
+			if diff := loaded.Expires.Sub(now.Add(tt.expires)); diff < -time.Second || diff > time.Second {
+				t.Errorf("expected expiry in %s, got %s", tt.expires, loaded.Expires.Sub(now))
+			}
+
+			if throttled := tt.seen > -6*time.Minute; throttled != loaded.Seen.Equal(seen) {
+				t.Errorf("expected seen to be updated only after a tenth of the timeout, got %s", loaded.Seen)
+			}
+
+			if cookie := len(w.Result().Cookies()) > 0; cookie != tt.cookie {
+				t.Errorf("expected cookie to be written: %v, got %v", tt.cookie, cookie)
+			}
+		})
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	m, store, cache := sessions(t, nil)
+
+	alice, _ := issue(t, m, "alice")
+	other, _ := issue(t, m, "Alice")
+	bob, _ := issue(t, m, "bob")
+
+	cache.Set("alice", "secret", alice, time.Hour)
+	cache.Set("bob", "secret", bob, time.Hour)
+
+	before := active(t)
+	count, err := m.RevokeUser("alice")
+
+	if err != nil {
+		t.Fatalf("failed to revoke sessions: %s", err)
+	}
+
+	if count != 2 {
+		t.Errorf("expected 2 revoked sessions, got %d", count)
+	}
+
+	for _, s := range []*Session{alice, other} {
+		if _, err := store.Load(s.ID); err != ErrRevokedSession {
+			t.Errorf("expected session of %s to be revoked, got %v", s.User, err)
+		}
+	}
+
+	if _, err := store.Load(bob.ID); err != nil {
+		t.Errorf("expected session of bob to be kept, got %s", err)
+	}
+
+	if _, ok := cache.Get("alice", "secret"); ok {
+		t.Errorf("expected cached credentials of alice to be dropped")
+	}
+
+	if _, ok := cache.Get("bob", "secret"); !ok {
+		t.Errorf("expected cached credentials of bob to be kept")
+	}
+
+	if after := active(t); after != before-2 {
+		t.Errorf("expected active sessions to drop from %v to %v, got %v", before, before-2, after)
+	}
+}
+
+func TestRevokeExpired(t *testing.T) {
+	m, store, _ := sessions(t, nil)
+	s, _ := issue(t, m, "alice")
+
+	s.Expires = time.Now().Add(-time.Minute)
+	store.Save(s)
+
+	// The prune already dropped expired sessions from the gauge.
+	before := active(t)
+
+	if err := m.Revoke(s); err != nil {
+		t.Fatalf("failed to revoke session: %s", err)
+	}
+
+	if after := active(t); after != before {
+		t.Errorf("expected active sessions to stay at %v, got %v", before, after)
+	}
+}
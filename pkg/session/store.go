@@ -0,0 +1,45 @@
+package session
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+// Store persists the sessions on the server side, this way sessions can be
+// listed and revoked and multiple instances are able to share them.
+type Store interface {
+	// Load returns the session with the given ID, ErrRevokedSession gets
+	// returned if the session doesn't exist.
+	Load(id string) (*Session, error)
+
+	// Save creates or replaces the session until it expires.
+	Save(s *Session) error
+
+	// Delete removes the session with the given ID.
+	Delete(id string) error
+
+	// List returns all sessions which are not expired yet.
+	List() ([]*Session, error)
+
+	// Prune removes all expired sessions.
+	Prune() error
+
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// NewStore initializes the configured session store.
+func NewStore(cfg *config.Config) (Store, error) {
+	switch cfg.Session.Store {
+	case "", "memory":
+		return NewMemoryStore(cfg.Session.Capacity), nil
+	case "file":
+		return NewFileStore(filepath.Join(cfg.Server.Storage, "sessions.db"))
+	case "redis":
+		return NewRedisStore(cfg.Session.Redis)
+	default:
+		return nil, fmt.Errorf("session store %q is not supported", cfg.Session.Store)
+	}
+}
@@ -0,0 +1,336 @@
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(0))
+}
+
+func TestMemoryStoreCapacity(t *testing.T) {
+	store := NewMemoryStore(2)
+	expires := time.Now().Add(time.Hour)
+
+	for _, id := range []string{"a", "b"} {
+		store.Save(&Session{ID: id, Expires: expires})
+	}
+
+	store.Load("a")
+	store.Save(&Session{ID: "c", Expires: expires})
+
+	if _, err := store.Load("b"); err != ErrRevokedSession {
+		t.Errorf("expected least recently used session to be evicted, got %v", err)
+	}
+
+	for _, id := range []string{"a", "c"} {
+		if _, err := store.Load(id); err != nil {
+			t.Errorf("expected session %s to be kept, got %s", id, err)
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(filepath.Join(dir, "sessions.db"))
+
+	if err != nil {
+		t.Fatalf("failed to open store: %s", err)
+	}
+
+	testStore(t, store)
+}
+
+func TestRedisStore(t *testing.T) {
+	l := redisServer(t)
+	defer l.Close()
+
+	store, err := NewRedisStore(config.Redis{
+		Addr:   l.Addr().String(),
+		Prefix: "sessions:",
+	})
+
+	if err != nil {
+		t.Fatalf("failed to connect store: %s", err)
+	}
+
+	testStore(t, store)
+}
+
+// testStore runs the behavior shared by all stores.
+func testStore(t *testing.T, store Store) {
+	defer store.Close()
+
+	now := time.Now()
+
+	active := &Session{
+		ID:      "active",
+		User:    "alice",
+		DN:      "uid=alice,ou=users,dc=example,dc=com",
+		Groups:  []string{"cn=users,ou=groups,dc=example,dc=com"},
+		Created: now,
+		Seen:    now,
+		Expires: now.Add(time.Hour),
+	}
+
+	if err := store.Save(active); err != nil {
+		t.Fatalf("failed to save session: %s", err)
+	}
+
+	expired := []string{"expired-1", "expired-2", "expired-3"}
+
+	for _, id := range expired {
+		if err := store.Save(&Session{ID: id, User: "bob", Expires: now.Add(-time.Minute)}); err != nil {
+			t.Fatalf("failed to save expired session: %s", err)
+		}
+	}
+
+	loaded, err := store.Load("active")
+
+	if err != nil {
+		t.Fatalf("failed to load session: %s", err)
+	}
+
+	if loaded.User != "alice" || loaded.DN != active.DN || len(loaded.Groups) != 1 || !loaded.Expires.Equal(active.Expires) {
+		t.Errorf("loaded session %+v doesn't match saved session %+v", loaded, active)
+	}
+
+	loaded.User = "mallory"
+
+	if again, _ := store.Load("active"); again.User != "alice" {
+		t.Errorf("expected loaded sessions to be copies")
+	}
+
+	if _, err := store.Load("missing"); err != ErrRevokedSession {
+		t.Errorf("expected %s for missing session, got %v", ErrRevokedSession, err)
+	}
+
+	active.Seen = now.Add(time.Minute)
+
+	if err := store.Save(active); err != nil {
+		t.Fatalf("failed to replace session: %s", err)
+	}
+
+	if loaded, _ := store.Load("active"); !loaded.Seen.Equal(active.Seen) {
+		t.Errorf("expected saved session to be replaced")
+	}
+
+	list, err := store.List()
+
+	if err != nil {
+		t.Fatalf("failed to list sessions: %s", err)
+	}
+
+	if len(list) != 1 || list[0].ID != "active" {
+		t.Errorf("expected only the active session to be listed, got %v", list)
+	}
+
+	if err := store.Prune(); err != nil {
+		t.Fatalf("failed to prune sessions: %s", err)
+	}
+
+	for _, id := range expired {
+		if _, err := store.Load(id); err != ErrRevokedSession {
+			t.Errorf("expected expired session %s to be pruned, got %v", id, err)
+		}
+	}
+
+	if err := store.Delete("active"); err != nil {
+		t.Fatalf("failed to delete session: %s", err)
+	}
+
+	if _, err := store.Load("active"); err != ErrRevokedSession {
+		t.Errorf("expected deleted session to be revoked, got %v", err)
+	}
+
+	if err := store.Delete("missing"); err != nil {
+		t.Errorf("expected deleting a missing session to succeed, got %s", err)
+	}
+}
+
+// redisServer starts a minimal stand-in for Redis which supports the
+// commands used by the store, closing the listener stops it.
+func redisServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	var (
+		mu      sync.Mutex
+		values  = make(map[string]string)
+		expires = make(map[string]time.Time)
+	)
+
+	lookup := func(key string) (string, bool) {
+		if exp, ok := expires[key]; ok && time.Now().After(exp) {
+			delete(values, key)
+			delete(expires, key)
+		}
+
+		value, ok := values[key]
+		return value, ok
+	}
+
+	bulk := func(value string) string {
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	}
+
+	handle := func(args []string) string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			return "+PONG\r\n"
+		case "GET":
+			if value, ok := lookup(args[1]); ok {
+				return bulk(value)
+			}
+
+			return "$-1\r\n"
+		case "SET":
+			values[args[1]] = args[2]
+			delete(expires, args[1])
+
+			for i := 3; i+1 < len(args); i += 2 {
+				amount, _ := strconv.Atoi(args[i+1])
+
+				switch strings.ToUpper(args[i]) {
+				case "EX":
+					expires[args[1]] = time.Now().Add(time.Duration(amount) * time.Second)
+				case "PX":
+					expires[args[1]] = time.Now().Add(time.Duration(amount) * time.Millisecond)
+				}
+			}
+
+			return "+OK\r\n"
+		case "DEL":
+			deleted := 0
+
+			for _, key := range args[1:] {
+				if _, ok := lookup(key); ok {
+					delete(values, key)
+					delete(expires, key)
+					deleted++
+				}
+			}
+
+			return fmt.Sprintf(":%d\r\n", deleted)
+		case "SCAN":
+			pattern := "*"
+
+			for i := 2; i+1 < len(args); i += 2 {
+				if strings.ToUpper(args[i]) == "MATCH" {
+					pattern = args[i+1]
+				}
+			}
+
+			keys := []string{}
+
+			for key := range values {
+				if _, ok := lookup(key); !ok {
+					continue
+				}
+
+				if ok, _ := path.Match(pattern, key); ok {
+					keys = append(keys, bulk(key))
+				}
+			}
+
+			return "*2\r\n" + bulk("0") + fmt.Sprintf("*%d\r\n", len(keys)) + strings.Join(keys, "")
+		default:
+			return "-ERR unknown command\r\n"
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+
+				for {
+					args, err := readCommand(reader)
+
+					if err != nil {
+						return
+					}
+
+					conn.Write([]byte(handle(args)))
+				}
+			}()
+		}
+	}()
+
+	return l
+}
+
+// readCommand reads a command encoded as array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+
+	args := make([]string, count)
+
+	for i := range args {
+		line, err := r.ReadString('\n')
+
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid argument %q", line)
+		}
+
+		buf := make([]byte, size+2)
+
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		args[i] = string(buf[:size])
+	}
+
+	return args, nil
+}
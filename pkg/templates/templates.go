@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/Masterminds/sprig"
 	"github.com/rs/zerolog/log"
@@ -17,8 +18,65 @@ import (
 
 //go:generate fileb0x ab0x.yaml
 
-// Load initializes the template files.
+var (
+	current *template.Template
+	mutex   sync.RWMutex
+)
+
+// Load returns the parsed template files, they get parsed on first usage.
 func Load(cfg *config.Config) *template.Template {
+	mutex.RLock()
+	tpls := current
+	mutex.RUnlock()
+
+	if tpls != nil {
+		return tpls
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if current == nil {
+		current = parse(cfg)
+	}
+
+	return current
+}
+
+// Prepare parses the template files again without activating them, the
+// returned function swaps them in. An error gets returned if the required
+// templates can't be rendered.
+func Prepare(cfg *config.Config) (func(), error) {
+	tpls := parse(cfg)
+
+	if err := check(tpls); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		current = tpls
+	}, nil
+}
+
+// Check verifies that the required templates can be rendered.
+func Check(cfg *config.Config) error {
+	return check(Load(cfg))
+}
+
+func check(tpls *template.Template) error {
+	for _, name := range []string{"login.tmpl", "logout.tmpl", "error.tmpl"} {
+		if err := tpls.ExecuteTemplate(ioutil.Discard, name, map[string]string{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parse(cfg *config.Config) *template.Template {
 	tpls := template.New(
 		"",
 	).Funcs(
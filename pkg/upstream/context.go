@@ -0,0 +1,18 @@
+package upstream
+
+import (
+	"context"
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of the context with the route attached.
+func NewContext(ctx context.Context, r *Route) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the route attached to the context, if any.
+func FromContext(ctx context.Context) (*Route, bool) {
+	r, ok := ctx.Value(contextKey{}).(*Route)
+	return r, ok
+}
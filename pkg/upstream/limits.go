@@ -0,0 +1,136 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// ErrorFunc renders the error page for failed upstream requests.
+type ErrorFunc func(w http.ResponseWriter, r *http.Request, status int, msg string)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+type limitKey struct{}
+
+// limitedBody fails reading the request body if it exceeds the limit and
+// remembers it to respond with the right status code.
+type limitedBody struct {
+	io.ReadCloser
+
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		b.exceeded = true
+		return 0, errBodyTooLarge
+	}
+
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+
+	if b.remaining < 0 {
+		b.exceeded = true
+		return n, errBodyTooLarge
+	}
+
+	return n, err
+}
+
+// limit rejects too large request bodies.
+func (p *pool) limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits := p.opts.limits
+
+		if limits.RequestBody > 0 {
+			if r.ContentLength > limits.RequestBody {
+				p.failure(w, r, errBodyTooLarge)
+				return
+			}
+
+			body := &limitedBody{
+				ReadCloser: r.Body,
+				remaining:  limits.RequestBody,
+			}
+
+			r.Body = body
+			r = r.WithContext(context.WithValue(r.Context(), limitKey{}, body))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// failure maps the errors of the buffer and the forwarder to error pages.
+func (p *pool) failure(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusBadGateway
+	msg := "The upstream server failed to respond"
+
+	if body, ok := r.Context().Value(limitKey{}).(*limitedBody); err == errBodyTooLarge || ok && body.exceeded {
+		status = http.StatusRequestEntityTooLarge
+		msg = "The request body exceeds the allowed size"
+	} else if timeout(r, err) {
+		status = http.StatusGatewayTimeout
+		msg = "The upstream server did not respond in time"
+	}
+
+	hlog.FromRequest(r).Warn().
+		Err(err).
+		Str("route", p.name).
+		Int("status", status).
+		Msg("failed to proxy request")
+
+	p.fail(w, r, status, msg)
+}
+
+func timeout(r *http.Request, err error) bool {
+	if r.Context().Err() == context.DeadlineExceeded || err == context.DeadlineExceeded {
+		return true
+	}
+
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return true
+	}
+
+	return false
+}
+
+// responseLimit maps a disabled limit to the maximum, the buffer doesn't
+// support to disable it explicitly.
+func responseLimit(limit int64) int64 {
+	if limit <= 0 {
+		return math.MaxInt64
+	}
+
+	return limit
+}
+
+// transport builds the upstream transport with the configured timeouts.
+func transport(dial, header time.Duration) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   dial,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ResponseHeaderTimeout: header,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
@@ -0,0 +1,447 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vulcand/oxy/buffer"
+	"github.com/vulcand/oxy/cbreaker"
+	"github.com/vulcand/oxy/forward"
+	"github.com/vulcand/oxy/roundrobin"
+	"github.com/vulcand/oxy/utils"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/metrics"
+)
+
+// EndpointStatus represents the health of a single endpoint.
+type EndpointStatus struct {
+	URL      string    `json:"url"`
+	Weight   int       `json:"weight"`
+	Healthy  bool      `json:"healthy"`
+	Failures int       `json:"failures"`
+	Error    string    `json:"error,omitempty"`
+	Checked  time.Time `json:"checked"`
+}
+
+type endpoint struct {
+	url      *url.URL
+	weight   int
+	healthy  bool
+	failures int
+	err      string
+	checked  time.Time
+}
+
+// balancer defines the shared methods of the roundrobin and the rebalancer.
+type balancer interface {
+	http.Handler
+	Servers() []*url.URL
+	RemoveServer(*url.URL) error
+	UpsertServer(*url.URL, ...roundrobin.ServerOption) error
+}
+
+// options defines the settings which require a new pool if they change.
+type options struct {
+	breaker config.Breaker
+	balance config.Balancer
+	flush   time.Duration
+	limits  config.Limits
+}
+
+// pool balances the requests between the healthy endpoints of a route.
+type pool struct {
+	name     string
+	opts     options
+	balancer balancer
+	forward  http.Handler
+	handler  http.Handler
+	stream   http.Handler
+	fail     ErrorFunc
+
+	mu        sync.RWMutex
+	endpoints []*endpoint
+	tripped   bool
+	cancel    context.CancelFunc
+}
+
+func newPool(name string, opts options, fail ErrorFunc) (*pool, error) {
+	p := &pool{
+		name: name,
+		opts: opts,
+		fail: fail,
+	}
+
+	fwd, err := forward.New(
+		forward.PassHostHeader(true),
+		forward.Stream(true),
+		forward.StreamingFlushInterval(opts.flush),
+		forward.RoundTripper(transport(opts.limits.DialTimeout, opts.limits.HeaderTimeout)),
+		forward.ErrorHandler(utils.ErrorHandlerFunc(p.failure)),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.forward = metrics.Upstream(fwd)
+
+	rr, err := roundrobin.New(
+		p.forward,
+		roundrobin.ErrorHandler(utils.ErrorHandlerFunc(p.failure)),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.balancer = rr
+
+	if opts.balance.Rebalance {
+		rb, err := roundrobin.NewRebalancer(
+			rr,
+			roundrobin.RebalancerErrorHandler(utils.ErrorHandlerFunc(p.failure)),
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.balancer = rb
+	}
+
+	var next http.Handler = p.balancer
+
+	if opts.balance.Sticky {
+		next = p.sticky(p.balancer)
+	}
+
+	p.stream = p.limit(next)
+
+	if breaker := opts.breaker; breaker.Expression != "" {
+		cbOpts := []cbreaker.CircuitBreakerOption{
+			cbreaker.OnTripped(effect(func() { p.trip(true) })),
+			cbreaker.OnStandby(effect(func() { p.trip(false) })),
+		}
+
+		if breaker.Fallback > 0 {
+			cbOpts = append(cbOpts, cbreaker.FallbackDuration(breaker.Fallback))
+		}
+
+		if breaker.Recovery > 0 {
+			cbOpts = append(cbOpts, cbreaker.RecoveryDuration(breaker.Recovery))
+		}
+
+		cb, err := cbreaker.New(next, breaker.Expression, cbOpts...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		next = cb
+	}
+
+	buf, err := buffer.New(
+		metrics.Retries(next),
+		buffer.Retry(`IsNetworkError() && Attempts() < 3`),
+		buffer.MaxResponseBodyBytes(responseLimit(opts.limits.ResponseBody)),
+		buffer.ErrorHandler(utils.ErrorHandlerFunc(p.failure)),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.handler = p.limit(metrics.Attempts(buf))
+
+	return p, nil
+}
+
+// sync adds new endpoints before it removes the outdated ones, this way the
+// balancer never runs without any upstream. The health of endpoints which
+// are still configured is kept.
+func (p *pool) sync(endpoints []config.Endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := make(map[string]*endpoint, len(p.endpoints))
+
+	for _, e := range p.endpoints {
+		current[e.url.String()] = e
+	}
+
+	result := make([]*endpoint, 0, len(endpoints))
+
+	for _, item := range endpoints {
+		parsed, err := url.Parse(item.URL)
+
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("endpoint", item.URL).
+				Msg("failed to parse endpoint")
+
+			continue
+		}
+
+		e, ok := current[parsed.String()]
+
+		if !ok {
+			e = &endpoint{
+				url:     parsed,
+				healthy: true,
+			}
+		}
+
+		e.weight = item.Weight
+
+		if e.weight <= 0 {
+			e.weight = 1
+		}
+
+		delete(current, parsed.String())
+		result = append(result, e)
+
+		if e.healthy {
+			p.upsert(e)
+		}
+	}
+
+	for _, e := range current {
+		p.remove(e)
+	}
+
+	p.endpoints = result
+}
+
+// start replaces the running health checks, all endpoints are treated as
+// healthy if the checks are disabled.
+func (p *pool) start(ctx context.Context, probe config.Probe) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+
+	if probe.Path == "" {
+		for _, e := range p.endpoints {
+			if !e.healthy {
+				e.healthy = true
+				e.failures = 0
+				e.err = ""
+
+				p.upsert(e)
+			}
+		}
+
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p.cancel = cancel
+
+	go p.run(ctx, probe)
+}
+
+// stop stops the running health checks.
+func (p *pool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+}
+
+func (p *pool) run(ctx context.Context, probe config.Probe) {
+	client := &http.Client{
+		Timeout: probe.Timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ticker := time.NewTicker(probe.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.probe(client, probe)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *pool) probe(client *http.Client, probe config.Probe) {
+	p.mu.RLock()
+	endpoints := append([]*endpoint{}, p.endpoints...)
+	p.mu.RUnlock()
+
+	for _, e := range endpoints {
+		err := check(client, e.url, probe.Path)
+
+		p.mu.Lock()
+		p.update(e, err, probe.Threshold)
+		p.mu.Unlock()
+	}
+}
+
+func (p *pool) update(e *endpoint, err error, threshold int) {
+	e.checked = time.Now()
+
+	if !p.contains(e) {
+		return
+	}
+
+	if err == nil {
+		e.failures = 0
+		e.err = ""
+
+		if !e.healthy {
+			log.Info().
+				Str("route", p.name).
+				Str("endpoint", e.url.String()).
+				Msg("upstream endpoint recovered")
+
+			e.healthy = true
+			p.upsert(e)
+		}
+
+		return
+	}
+
+	e.failures++
+	e.err = err.Error()
+
+	if e.healthy && e.failures >= threshold {
+		log.Warn().
+			Err(err).
+			Str("route", p.name).
+			Str("endpoint", e.url.String()).
+			Msg("upstream endpoint failed, removing from balancer")
+
+		e.healthy = false
+		p.remove(e)
+	}
+}
+
+func (p *pool) contains(e *endpoint) bool {
+	for _, current := range p.endpoints {
+		if current == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *pool) upsert(e *endpoint) {
+	if err := p.balancer.UpsertServer(e.url, roundrobin.Weight(e.weight)); err != nil {
+		log.Warn().
+			Err(err).
+			Str("endpoint", e.url.String()).
+			Msg("failed to add endpoint")
+	}
+}
+
+func (p *pool) remove(e *endpoint) {
+	if err := p.balancer.RemoveServer(e.url); err != nil {
+		log.Warn().
+			Err(err).
+			Str("endpoint", e.url.String()).
+			Msg("failed to remove endpoint")
+	}
+}
+
+func (p *pool) trip(tripped bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tripped = tripped
+
+	if tripped {
+		log.Warn().
+			Str("route", p.name).
+			Str("expression", p.opts.breaker.Expression).
+			Msg("circuit breaker tripped")
+	} else {
+		log.Info().
+			Str("route", p.name).
+			Msg("circuit breaker recovered")
+	}
+}
+
+func (p *pool) size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return len(p.endpoints)
+}
+
+func (p *pool) status() ([]EndpointStatus, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]EndpointStatus, 0, len(p.endpoints))
+
+	for _, e := range p.endpoints {
+		result = append(result, EndpointStatus{
+			URL:      e.url.String(),
+			Weight:   e.weight,
+			Healthy:  e.healthy,
+			Failures: e.failures,
+			Error:    e.err,
+			Checked:  e.checked,
+		})
+	}
+
+	switch {
+	case p.opts.breaker.Expression == "":
+		return result, BreakerDisabled
+	case p.tripped:
+		return result, BreakerTripped
+	default:
+		return result, BreakerStandby
+	}
+}
+
+func check(client *http.Client, u *url.URL, probe string) error {
+	target := *u
+	target.Path = path.Join("/", u.Path, probe)
+
+	resp, err := client.Get(target.String())
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// effect adapts a function to the side effects of the circuit breaker.
+type effect func()
+
+func (e effect) Exec() error {
+	e()
+	return nil
+}
@@ -0,0 +1,82 @@
+package upstream
+
+import (
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/url"
+
+	"github.com/webhippie/ldap-proxy/pkg/session"
+)
+
+// sticky forwards the requests of authenticated users always to the same
+// healthy endpoint, requests without a session are passed to the balancer.
+func (p *pool) sticky(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := session.FromContext(r.Context())
+
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		target := p.pick(s.User)
+
+		if target == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+
+		p.forward.ServeHTTP(w, r)
+	})
+}
+
+// pick selects the endpoint for a user by weighted rendezvous hashing, this
+// way only the users of an added or removed endpoint get moved.
+func (p *pool) pick(user string) *url.URL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var (
+		result *url.URL
+		best   = math.Inf(-1)
+	)
+
+	for _, e := range p.endpoints {
+		if !e.healthy {
+			continue
+		}
+
+		h := fnv.New64a()
+		h.Write([]byte(user))
+		h.Write([]byte{0})
+		h.Write([]byte(e.url.String()))
+
+		// Map the hash into (0, 1) and weight the score like a sample of an
+		// exponential distribution.
+		f := (float64(mix(h.Sum64())>>11) + 0.5) / (1 << 53)
+		score := -float64(e.weight) / math.Log(f)
+
+		if score > best {
+			best = score
+			result = e.url
+		}
+	}
+
+	return result
+}
+
+// mix spreads the bits of the hash, similar endpoint URLs would result in
+// correlated scores otherwise.
+func mix(z uint64) uint64 {
+	z ^= z >> 30
+	z *= 0xbf58476d1ce4e5b9
+	z ^= z >> 27
+	z *= 0x94d049bb133111eb
+	z ^= z >> 31
+
+	return z
+}
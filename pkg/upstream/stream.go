@@ -0,0 +1,233 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/webhippie/ldap-proxy/pkg/metrics"
+	"github.com/webhippie/ldap-proxy/pkg/policy"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+)
+
+const (
+	// StreamWebsocket defines the kind of websocket upgrades.
+	StreamWebsocket = "websocket"
+
+	// StreamUpgrade defines the kind of other protocol upgrades.
+	StreamUpgrade = "upgrade"
+
+	// StreamEvents defines the kind of server-sent event streams.
+	StreamEvents = "sse"
+
+	// StreamPath defines the kind of requests to the streaming paths.
+	StreamPath = "stream"
+)
+
+// streaming detects the kind of stream, an empty string is returned for
+// regular requests.
+func (r *Route) streaming(req *http.Request) string {
+	if header(req, "Connection", "upgrade") && req.Header.Get("Upgrade") != "" {
+		if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			return StreamWebsocket
+		}
+
+		return StreamUpgrade
+	}
+
+	if header(req, "Accept", "text/event-stream") {
+		return StreamEvents
+	}
+
+	if r.streamPath(req) {
+		return StreamPath
+	}
+
+	return ""
+}
+
+// streamPath checks if the request matches one of the configured stream
+// paths.
+func (r *Route) streamPath(req *http.Request) bool {
+	for _, path := range r.stream.Paths {
+		if policy.MatchPath(path, policy.Path(req)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unlimited checks if the request runs without a timeout, only upgrade
+// handshakes and the configured stream paths qualify. Clients can't avoid the
+// timeout just by asking for an event stream.
+func (r *Route) unlimited(req *http.Request) bool {
+	switch r.streaming(req) {
+	case StreamWebsocket, StreamUpgrade:
+		return req.Method == http.MethodGet
+	}
+
+	return r.streamPath(req)
+}
+
+// events checks if the request asks for an event stream which is bounded by
+// the stream lifetime instead of the request timeout.
+func (r *Route) events(req *http.Request) bool {
+	return r.stream.Lifetime > 0 && req.Method == http.MethodGet && r.streaming(req) == StreamEvents
+}
+
+// serveStream forwards the stream without buffering and closes it after the
+// lifetime or when the session expires.
+func (r *Route) serveStream(w http.ResponseWriter, req *http.Request, kind string) {
+	lifetime := r.stream.Lifetime
+
+	if s, ok := session.FromContext(req.Context()); ok {
+		if remaining := time.Until(s.Expires); lifetime <= 0 || remaining < lifetime {
+			lifetime = remaining
+		}
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	sw := &streamWriter{
+		ResponseWriter: w,
+	}
+
+	if lifetime > 0 {
+		timer := time.AfterFunc(lifetime, func() {
+			hlog.FromRequest(req).Debug().
+				Str("route", r.Name).
+				Str("kind", kind).
+				Msg("closing stream after lifetime")
+
+			cancel()
+			sw.close()
+		})
+
+		defer timer.Stop()
+	}
+
+	metrics.ProxyStreamsTotal.WithLabelValues(kind).Inc()
+	metrics.ProxyStreams.WithLabelValues(kind).Inc()
+	defer metrics.ProxyStreams.WithLabelValues(kind).Dec()
+
+	r.pool.stream.ServeHTTP(sw, req.WithContext(ctx))
+}
+
+// Timeout returns the request timeout of the matching route, upgraded
+// connections and stream paths are not limited by any timeout. Event streams
+// are limited by the stream lifetime instead if it's defined.
+func (t *Table) Timeout(req *http.Request) time.Duration {
+	route, ok := t.Match(req)
+
+	if !ok {
+		t.mu.RLock()
+		defer t.mu.RUnlock()
+
+		return t.fallback.limits.Timeout
+	}
+
+	if route.unlimited(req) {
+		return 0
+	}
+
+	if route.events(req) {
+		return route.stream.Lifetime
+	}
+
+	return route.limits.Timeout
+}
+
+// streamWriter keeps track of hijacked connections to close them after the
+// lifetime of the stream.
+type streamWriter struct {
+	http.ResponseWriter
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// Flush implements the http.Flusher interface.
+func (w *streamWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify implements the http.CloseNotifier interface.
+func (w *streamWriter) CloseNotify() <-chan bool {
+	if n, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return n.CloseNotify()
+	}
+
+	return make(chan bool)
+}
+
+// ReadFrom implements the io.ReaderFrom interface, wrapping writers like the
+// ones of chi drop the other interfaces without it.
+func (w *streamWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+
+	return io.Copy(w.ResponseWriter, src)
+}
+
+// Hijack implements the http.Hijacker interface.
+func (w *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	conn, rw, err := h.Hijack()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		conn.Close()
+		return nil, nil, errors.New("stream lifetime exceeded")
+	}
+
+	w.conn = conn
+
+	return conn, rw, nil
+}
+
+func (w *streamWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+
+	if w.conn != nil {
+		w.conn.Close()
+	}
+}
+
+func header(req *http.Request, name, value string) bool {
+	for _, field := range req.Header[name] {
+		for _, part := range strings.Split(field, ",") {
+			if strings.EqualFold(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]), value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,267 @@
+package upstream
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	middleware "github.com/webhippie/ldap-proxy/pkg/middleware/timeout"
+)
+
+// routes builds the routes with a default route to the backend.
+func routes(t *testing.T, backend *httptest.Server, stream config.Stream, limits config.Limits) *Table {
+	cfg := config.New()
+	cfg.Proxy.Endpoints = []config.Endpoint{
+		{URL: backend.URL},
+	}
+	cfg.Proxy.Stream = stream
+	cfg.Proxy.Limits = limits
+
+	table, err := New(cfg, func(w http.ResponseWriter, r *http.Request, status int, msg string) {
+		http.Error(w, msg, status)
+	})
+
+	if err != nil {
+		t.Fatalf("failed to build routes: %s", err)
+	}
+
+	return table
+}
+
+// proxy starts a server which forwards all requests to the backend like the
+// router does it, including the timeout of the routes.
+func proxy(t *testing.T, backend *httptest.Server, stream config.Stream, limits config.Limits) *httptest.Server {
+	table := routes(t, backend, stream, limits)
+
+	return httptest.NewServer(middleware.Timeout(table.Timeout)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		table.Lookup(r).ServeHTTP(w, r)
+	})))
+}
+
+func TestWebsocket(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()
+
+		fmt.Fprint(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+
+		for {
+			line, err := rw.ReadString('\n')
+
+			if err != nil {
+				return
+			}
+
+			fmt.Fprint(rw, "echo "+line)
+			rw.Flush()
+		}
+	}))
+
+	defer backend.Close()
+
+	srv := proxy(t, backend, config.Stream{}, config.Limits{})
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprint(conn, "GET /socket HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+
+	if err != nil {
+		t.Fatalf("failed to read handshake: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+
+	for _, msg := range []string{"one", "two"} {
+		fmt.Fprint(conn, msg+"\n")
+
+		line, err := reader.ReadString('\n')
+
+		if err != nil {
+			t.Fatalf("failed to read message: %s", err)
+		}
+
+		if line != "echo "+msg+"\n" {
+			t.Errorf("expected echo of %q, got %q", msg, line)
+		}
+	}
+}
+
+func TestEventStreamFlush(t *testing.T) {
+	done := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+
+		fmt.Fprint(w, "data: one\n\n")
+		w.(http.Flusher).Flush()
+
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+
+	defer backend.Close()
+	defer close(done)
+
+	srv := proxy(t, backend, config.Stream{Flush: 10 * time.Millisecond}, config.Limits{})
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatalf("failed to request stream: %s", err)
+	}
+
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+
+	if err != nil {
+		t.Fatalf("expected the event before the stream ends, got %s", err)
+	}
+
+	if line != "data: one\n" {
+		t.Errorf("expected first event, got %q", line)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	defer backend.Close()
+
+	limits := config.Limits{
+		Timeout: time.Minute,
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		headers  map[string]string
+		lifetime time.Duration
+		want     time.Duration
+	}{
+		{"regular", "GET", "/", nil, time.Hour, time.Minute},
+		{"websocket", "GET", "/", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, 0, 0},
+		{"websocket post", "POST", "/", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, 0, time.Minute},
+		{"stream path", "GET", "/stream/x", nil, 0, 0},
+		{"events", "GET", "/", map[string]string{"Accept": "text/event-stream"}, time.Hour, time.Hour},
+		{"events without lifetime", "GET", "/", map[string]string{"Accept": "text/event-stream"}, 0, time.Minute},
+		{"events post", "POST", "/", map[string]string{"Accept": "text/event-stream"}, time.Hour, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := routes(t, backend, config.Stream{Lifetime: tt.lifetime, Paths: []string{"/stream"}}, limits)
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			if got := table.Timeout(req); got != tt.want {
+				t.Errorf("expected timeout %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEventStreamLifetime(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				fmt.Fprint(w, "data: tick\n\n")
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+
+	defer backend.Close()
+
+	tests := []struct {
+		name     string
+		lifetime time.Duration
+		min      time.Duration
+		max      time.Duration
+	}{
+		{"outlives timeout", 600 * time.Millisecond, 500 * time.Millisecond, 3 * time.Second},
+		{"bounded by timeout", 0, 100 * time.Millisecond, 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := proxy(t, backend, config.Stream{Lifetime: tt.lifetime, Flush: 10 * time.Millisecond}, config.Limits{Timeout: 200 * time.Millisecond})
+			defer srv.Close()
+
+			req, _ := http.NewRequest("GET", srv.URL+"/events", nil)
+			req.Header.Set("Accept", "text/event-stream")
+
+			client := &http.Client{
+				Timeout: 5 * time.Second,
+			}
+
+			start := time.Now()
+			resp, err := client.Do(req)
+
+			if err != nil {
+				t.Fatalf("failed to request stream: %s", err)
+			}
+
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if elapsed := time.Since(start); elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("expected stream to end between %s and %s, took %s", tt.min, tt.max, elapsed)
+			}
+		})
+	}
+}
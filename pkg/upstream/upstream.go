@@ -0,0 +1,514 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/webhippie/ldap-proxy/pkg/config"
+	"github.com/webhippie/ldap-proxy/pkg/policy"
+	"github.com/webhippie/ldap-proxy/pkg/session"
+)
+
+const (
+	// DefaultRoute defines the name of the route built from the global proxy
+	// configuration, it handles all requests without a matching route.
+	DefaultRoute = "default"
+
+	// BreakerDisabled defines the state of routes without a circuit breaker.
+	BreakerDisabled = "disabled"
+
+	// BreakerStandby defines the state of a circuit breaker passing requests.
+	BreakerStandby = "standby"
+
+	// BreakerTripped defines the state of a circuit breaker rejecting requests.
+	BreakerTripped = "tripped"
+)
+
+// Status represents the state of a route and its endpoints.
+type Status struct {
+	Route     string           `json:"route"`
+	Breaker   string           `json:"breaker"`
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+// Route forwards the matching requests to a dedicated pool of endpoints.
+type Route struct {
+	Name       string
+	Host       string
+	Path       string
+	StripPath  bool
+	UserHeader string
+	Headers    []config.Header
+	Access     *policy.Policy
+	Basic      config.Basic
+
+	probe  config.Probe
+	stream config.Stream
+	limits config.Limits
+	pool   *pool
+}
+
+// ServeHTTP forwards the request to the endpoints of the route, streams
+// bypass the buffer.
+func (r *Route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	kind := r.streaming(req)
+
+	if r.StripPath && r.Path != "" {
+		req.URL.Path = "/" + strings.TrimLeft(strings.TrimPrefix(policy.Path(req), strings.TrimSuffix(r.Path, "/")), "/")
+		req.URL.RawPath = ""
+	}
+
+	if kind != "" {
+		r.serveStream(w, req, kind)
+		return
+	}
+
+	r.pool.handler.ServeHTTP(w, req)
+}
+
+// Servers returns the healthy endpoints of the route.
+func (r *Route) Servers() []*url.URL {
+	return r.pool.balancer.Servers()
+}
+
+func (r *Route) matches(req *http.Request) bool {
+	if r.Host != "" && !policy.MatchHost(r.Host, req.Host) {
+		return false
+	}
+
+	if r.Path != "" && !policy.MatchPath(r.Path, policy.Path(req)) {
+		return false
+	}
+
+	return true
+}
+
+// Table routes the requests by host and path prefix to the upstream pools.
+type Table struct {
+	mu       sync.RWMutex
+	ctx      context.Context
+	fail     ErrorFunc
+	routes   []*Route
+	fallback *Route
+}
+
+// New builds the routes based on the configuration, failed upstream requests
+// are rendered by the error function.
+func New(cfg *config.Config, fail ErrorFunc) (*Table, error) {
+	t := &Table{
+		fail: fail,
+	}
+
+	if err := t.Update(cfg); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Update rebuilds the routes based on the configuration, the pools of routes
+// with the same name are reused to keep their connections and state.
+func (t *Table) Update(cfg *config.Config) error {
+	apply, err := t.Prepare(cfg)
+
+	if err != nil {
+		return err
+	}
+
+	apply()
+	return nil
+}
+
+// Prepare builds the routes of the configuration without applying them, the
+// returned function swaps them in. Invalid configurations don't change the
+// current routes.
+func (t *Table) Prepare(cfg *config.Config) (func(), error) {
+	t.mu.RLock()
+	existing := make(map[string]*pool, len(t.routes)+1)
+
+	for _, r := range t.routes {
+		existing[r.Name] = r.pool
+	}
+
+	if t.fallback != nil {
+		existing[DefaultRoute] = t.fallback.pool
+	}
+	t.mu.RUnlock()
+
+	global, err := policy.New(cfg.Access)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fallback, err := build(config.Route{
+		Name:      DefaultRoute,
+		Endpoints: cfg.Proxy.Endpoints,
+	}, cfg, global, existing, t.fail)
+
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]*Route, 0, len(cfg.Proxy.Routes))
+
+	for _, r := range cfg.Proxy.Routes {
+		if r.Name == "" {
+			r.Name = r.Host + r.Path
+		}
+
+		route, err := build(r, cfg, global, existing, t.fail)
+
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, route)
+	}
+
+	return func() {
+		fallback.pool.sync(cfg.Proxy.Endpoints)
+
+		for i, r := range cfg.Proxy.Routes {
+			routes[i].pool.sync(r.Endpoints)
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		previous := append([]*Route{t.fallback}, t.routes...)
+
+		t.routes = routes
+		t.fallback = fallback
+
+		used := make(map[*pool]bool, len(routes)+1)
+
+		for _, r := range append([]*Route{fallback}, routes...) {
+			used[r.pool] = true
+
+			if t.ctx != nil {
+				r.pool.start(t.ctx, r.probe)
+			}
+		}
+
+		for _, r := range previous {
+			if r != nil && !used[r.pool] {
+				r.pool.stop()
+			}
+		}
+	}, nil
+}
+
+// Run executes the health checks of all routes until the context gets
+// canceled.
+func (t *Table) Run(ctx context.Context) error {
+	t.mu.Lock()
+	t.ctx = ctx
+
+	for _, r := range append([]*Route{t.fallback}, t.routes...) {
+		r.pool.start(ctx, r.probe)
+	}
+	t.mu.Unlock()
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// Status returns the state of all routes and their endpoints.
+func (t *Table) Status() []Status {
+	routes := t.Routes()
+	result := make([]Status, 0, len(routes))
+
+	for _, r := range routes {
+		endpoints, breaker := r.pool.status()
+
+		result = append(result, Status{
+			Route:     r.Name,
+			Breaker:   breaker,
+			Endpoints: endpoints,
+		})
+	}
+
+	return result
+}
+
+// Match returns the first route matching the request, requests without a
+// matching route are handled by the default route if it got endpoints.
+func (t *Table) Match(r *http.Request) (*Route, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, route := range t.routes {
+		if route.matches(r) {
+			return route, true
+		}
+	}
+
+	if t.fallback != nil && t.fallback.pool.size() > 0 {
+		return t.fallback, true
+	}
+
+	return nil, false
+}
+
+// Lookup returns the first route matching the request, the default route is
+// returned even without endpoints to apply the global access policy.
+func (t *Table) Lookup(r *http.Request) *Route {
+	if route, ok := t.Match(r); ok {
+		return route
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.fallback
+}
+
+// Hosts returns the hosts of all routes.
+func (t *Table) Hosts() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]string, 0, len(t.routes))
+
+	for _, r := range t.routes {
+		if r.Host != "" {
+			result = append(result, r.Host)
+		}
+	}
+
+	return result
+}
+
+// Routes returns all routes including the default route.
+func (t *Table) Routes() []*Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	result := make([]*Route, 0, len(t.routes)+1)
+	result = append(result, t.fallback)
+	result = append(result, t.routes...)
+
+	return result
+}
+
+// Servers returns the endpoints of all routes.
+func (t *Table) Servers() []*url.URL {
+	result := []*url.URL{}
+
+	for _, route := range t.Routes() {
+		result = append(result, route.Servers()...)
+	}
+
+	return result
+}
+
+// Handler attaches the matching route to the request and denies access to
+// requests with a session which is not allowed by the policy of the route.
+func (t *Table) Handler(forbidden http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, ok := t.Match(r)
+
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if s, ok := session.FromContext(r.Context()); ok && !route.Access.Allowed(r, s.Groups) {
+				hlog.FromRequest(r).Info().
+					Str("username", s.User).
+					Str("route", route.Name).
+					Msg("access denied by policy")
+
+				forbidden.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), route)))
+		})
+	}
+}
+
+func build(r config.Route, cfg *config.Config, global *policy.Policy, existing map[string]*pool, fail ErrorFunc) (*Route, error) {
+	route := &Route{
+		Name:       r.Name,
+		Host:       strings.ToLower(r.Host),
+		Path:       r.Path,
+		StripPath:  r.StripPath,
+		UserHeader: r.UserHeader,
+		Headers:    r.Headers,
+		Access:     global,
+		Basic:      cfg.Proxy.Basic,
+		probe:      cfg.Proxy.Probe,
+		stream:     cfg.Proxy.Stream,
+		limits:     cfg.Proxy.Limits,
+	}
+
+	breaker := cfg.Proxy.Breaker
+	balance := cfg.Proxy.Balancer
+
+	if r.Probe != nil {
+		route.probe = mergeProbe(route.probe, *r.Probe)
+	}
+
+	if r.Breaker != nil {
+		breaker = mergeBreaker(breaker, *r.Breaker)
+	}
+
+	if r.Balancer != nil {
+		balance = *r.Balancer
+	}
+
+	if r.Stream != nil {
+		route.stream = mergeStream(route.stream, *r.Stream)
+	}
+
+	if r.Limits != nil {
+		route.limits = mergeLimits(route.limits, *r.Limits)
+	}
+
+	if r.Basic != nil {
+		route.Basic = mergeBasic(route.Basic, *r.Basic)
+	}
+
+	opts := options{
+		breaker: breaker,
+		balance: balance,
+		flush:   route.stream.Flush,
+		limits:  route.limits,
+	}
+
+	if route.UserHeader == "" {
+		route.UserHeader = cfg.Proxy.UserHeader
+	}
+
+	if route.Headers == nil {
+		route.Headers = cfg.Proxy.Headers
+	}
+
+	if r.Access != nil {
+		access, err := policy.New(*r.Access)
+
+		if err != nil {
+			return nil, err
+		}
+
+		route.Access = access
+	}
+
+	if p, ok := existing[r.Name]; ok && p.opts == opts {
+		route.pool = p
+	} else {
+		p, err := newPool(r.Name, opts, fail)
+
+		if err != nil {
+			return nil, err
+		}
+
+		route.pool = p
+	}
+
+	return route, nil
+}
+
+// mergeProbe overrides the global health check with the values of a route.
+func mergeProbe(global, route config.Probe) config.Probe {
+	if route.Path != "" {
+		global.Path = route.Path
+	}
+
+	if route.Interval > 0 {
+		global.Interval = route.Interval
+	}
+
+	if route.Timeout > 0 {
+		global.Timeout = route.Timeout
+	}
+
+	if route.Threshold > 0 {
+		global.Threshold = route.Threshold
+	}
+
+	return global
+}
+
+// mergeBreaker overrides the global circuit breaker with the values of a
+// route.
+func mergeBreaker(global, route config.Breaker) config.Breaker {
+	if route.Expression != "" {
+		global.Expression = route.Expression
+	}
+
+	if route.Fallback > 0 {
+		global.Fallback = route.Fallback
+	}
+
+	if route.Recovery > 0 {
+		global.Recovery = route.Recovery
+	}
+
+	return global
+}
+
+// mergeStream overrides the global stream settings with the values of a
+// route.
+func mergeStream(global, route config.Stream) config.Stream {
+	if route.Lifetime > 0 {
+		global.Lifetime = route.Lifetime
+	}
+
+	if route.Flush > 0 {
+		global.Flush = route.Flush
+	}
+
+	if route.Paths != nil {
+		global.Paths = route.Paths
+	}
+
+	return global
+}
+
+// mergeLimits overrides the global limits with the values of a route.
+func mergeLimits(global, route config.Limits) config.Limits {
+	if route.RequestBody > 0 {
+		global.RequestBody = route.RequestBody
+	}
+
+	if route.ResponseBody > 0 {
+		global.ResponseBody = route.ResponseBody
+	}
+
+	if route.Timeout > 0 {
+		global.Timeout = route.Timeout
+	}
+
+	if route.DialTimeout > 0 {
+		global.DialTimeout = route.DialTimeout
+	}
+
+	if route.HeaderTimeout > 0 {
+		global.HeaderTimeout = route.HeaderTimeout
+	}
+
+	return global
+}
+
+// mergeBasic replaces the global basic authentication with the settings of a
+// route, a missing realm or TTL is inherited.
+func mergeBasic(global, route config.Basic) config.Basic {
+	if route.Realm == "" {
+		route.Realm = global.Realm
+	}
+
+	if route.TTL == 0 {
+		route.TTL = global.TTL
+	}
+
+	return route
+}